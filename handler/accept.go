@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"nhooyr.io/websocket"
+)
+
+// ProtocolJSON is the current JSON protocol. Clients may offer it with
+// Sec-WebSocket-Protocol; clients that offer nothing get it too, so it stays
+// available for debugging.
+const ProtocolJSON = "ourgatther.v1"
+
+// UpgradeConfig controls which WebSocket upgrades /ws accepts.
+type UpgradeConfig struct {
+	// AllowedOrigins lists host patterns (path.Match syntax, e.g.
+	// "*.example.com") allowed besides the server's own host. "*" allows
+	// any origin and should only be used in development.
+	AllowedOrigins []string
+
+	// Subprotocols lists the protocol versions the server speaks, preferred
+	// first. A client offering none of them is rejected.
+	Subprotocols []string
+
+	// MaxMessageSize is the largest frame read from a client, in bytes.
+	// Bigger frames close the connection.
+	MaxMessageSize int64
+
+	// Compression enables permessage-deflate for clients that offer it.
+	// Frames smaller than CompressionThreshold bytes are sent uncompressed;
+	// zero uses the library default.
+	Compression          websocket.CompressionMode
+	CompressionThreshold int
+}
+
+// upgradeError is a rejected upgrade with the status to answer it with.
+type upgradeError struct {
+	status  int
+	message string
+}
+
+func (e *upgradeError) Error() string { return e.message }
+
+// checkUpgrade validates r as a WebSocket upgrade before any work is done for
+// it, so bad requests get a clear status instead of a failed handshake.
+func (cfg UpgradeConfig) checkUpgrade(r *http.Request) *upgradeError {
+	if r.Method != http.MethodGet {
+		return &upgradeError{http.StatusMethodNotAllowed, "Method not allowed"}
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return &upgradeError{http.StatusUpgradeRequired, "WebSocket upgrade required"}
+	}
+	if err := cfg.checkOrigin(r); err != nil {
+		return &upgradeError{http.StatusForbidden, err.Error()}
+	}
+	if offered := headerTokens(r.Header, "Sec-WebSocket-Protocol"); len(offered) > 0 && cfg.subprotocol(offered) == "" {
+		return &upgradeError{http.StatusBadRequest, fmt.Sprintf("Unsupported subprotocol; this server speaks %s", strings.Join(cfg.Subprotocols, ", "))}
+	}
+	return nil
+}
+
+func (cfg UpgradeConfig) checkOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil // not a browser
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("Invalid Origin %q", origin)
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+	for _, pattern := range cfg.AllowedOrigins {
+		if ok, _ := filepath.Match(strings.ToLower(pattern), strings.ToLower(u.Host)); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("Origin %q is not allowed", u.Host)
+}
+
+// subprotocol picks the first of our subprotocols the client offered.
+func (cfg UpgradeConfig) subprotocol(offered []string) string {
+	for _, sp := range cfg.Subprotocols {
+		for _, o := range offered {
+			if strings.EqualFold(sp, o) {
+				return sp
+			}
+		}
+	}
+	return ""
+}
+
+func (cfg UpgradeConfig) acceptOptions() *websocket.AcceptOptions {
+	opts := &websocket.AcceptOptions{
+		Subprotocols:         cfg.Subprotocols,
+		CompressionMode:      cfg.Compression,
+		CompressionThreshold: cfg.CompressionThreshold,
+	}
+	for _, pattern := range cfg.AllowedOrigins {
+		if pattern == "*" {
+			opts.InsecureSkipVerify = true
+		}
+	}
+	if !opts.InsecureSkipVerify {
+		opts.OriginPatterns = cfg.AllowedOrigins
+	}
+	return opts
+}
+
+func headerTokens(h http.Header, key string) []string {
+	var tokens []string
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tokens = append(tokens, t)
+			}
+		}
+	}
+	return tokens
+}
+
+func headerContains(h http.Header, key, token string) bool {
+	for _, t := range headerTokens(h, key) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
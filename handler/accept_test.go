@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckUpgrade(t *testing.T) {
+	cfg := UpgradeConfig{
+		AllowedOrigins: []string{"*.example.com"},
+		Subprotocols:   []string{ProtocolMsgpack, ProtocolJSON},
+	}
+	for _, tt := range []struct {
+		name     string
+		method   string
+		origin   string
+		protocol string
+		upgrade  bool
+		want     int // 0 if accepted
+	}{
+		{"no origin", http.MethodGet, "", "", true, 0},
+		{"same host", http.MethodGet, "http://game.test", "", true, 0},
+		{"allowed origin", http.MethodGet, "https://play.example.com", "", true, 0},
+		{"other origin", http.MethodGet, "https://evil.test", "", true, http.StatusForbidden},
+		{"bad origin", http.MethodGet, "::", "", true, http.StatusForbidden},
+		{"known subprotocol", http.MethodGet, "", "foo, " + ProtocolJSON, true, 0},
+		{"unknown subprotocol", http.MethodGet, "", "ourgatther.v0", true, http.StatusBadRequest},
+		{"not an upgrade", http.MethodGet, "", "", false, http.StatusUpgradeRequired},
+		{"wrong method", http.MethodPost, "", "", true, http.StatusMethodNotAllowed},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "http://game.test/ws", nil)
+			if tt.upgrade {
+				r.Header.Set("Connection", "keep-alive, Upgrade")
+				r.Header.Set("Upgrade", "websocket")
+			}
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.protocol != "" {
+				r.Header.Set("Sec-WebSocket-Protocol", tt.protocol)
+			}
+
+			err := cfg.checkUpgrade(r)
+			switch {
+			case tt.want == 0 && err != nil:
+				t.Errorf("rejected with %d: %s", err.status, err.message)
+			case tt.want != 0 && (err == nil || err.status != tt.want):
+				t.Errorf("got %v, want status %d", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+)
+
+// maxViewSize caps the viewport a client may ask for, so nobody can
+// subscribe to the whole map by claiming a huge screen.
+const maxViewSize = 4096
+
+// rect is an area of the world, in pixels, inclusive of its edges.
+type rect struct{ minX, minY, maxX, maxY int }
+
+func (r rect) contains(x, y int) bool {
+	return x >= r.minX && x <= r.maxX && y >= r.minY && y <= r.maxY
+}
+
+type cellKey struct{ cx, cy int }
+
+// spatialGrid buckets player positions into square cells so the players in
+// an area can be found without looking at everyone. It isn't safe for
+// concurrent use; the world's lock guards it.
+type spatialGrid struct {
+	size  int
+	cells map[cellKey]map[int]struct{}
+	where map[int]cellKey
+}
+
+func newSpatialGrid(size int) *spatialGrid {
+	return &spatialGrid{
+		size:  size,
+		cells: make(map[cellKey]map[int]struct{}),
+		where: make(map[int]cellKey),
+	}
+}
+
+func (g *spatialGrid) key(x, y int) cellKey {
+	return cellKey{floorDiv(x, g.size), floorDiv(y, g.size)}
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// set records player id at (x, y).
+func (g *spatialGrid) set(id, x, y int) {
+	k := g.key(x, y)
+	if old, ok := g.where[id]; ok {
+		if old == k {
+			return
+		}
+		g.removeFrom(old, id)
+	}
+	if g.cells[k] == nil {
+		g.cells[k] = make(map[int]struct{})
+	}
+	g.cells[k][id] = struct{}{}
+	g.where[id] = k
+}
+
+func (g *spatialGrid) remove(id int) {
+	if k, ok := g.where[id]; ok {
+		g.removeFrom(k, id)
+		delete(g.where, id)
+	}
+}
+
+func (g *spatialGrid) removeFrom(k cellKey, id int) {
+	delete(g.cells[k], id)
+	if len(g.cells[k]) == 0 {
+		delete(g.cells, k)
+	}
+}
+
+// query calls fn for every player in a cell overlapping r; callers still
+// check exact positions.
+func (g *spatialGrid) query(r rect, fn func(id int)) {
+	lo, hi := g.key(r.minX, r.minY), g.key(r.maxX, r.maxY)
+	for cx := lo.cx; cx <= hi.cx; cx++ {
+		for cy := lo.cy; cy <= hi.cy; cy++ {
+			for id := range g.cells[cellKey{cx, cy}] {
+				fn(id)
+			}
+		}
+	}
+}
+
+// ViewMessage tells the server the size of the client's screen and, for
+// clients not controlling a player, where their camera is centred.
+type ViewMessage struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func (m *ViewMessage) Validate() error {
+	if m.Width <= 0 || m.Height <= 0 {
+		return errors.New("width and height must be positive")
+	}
+	if m.Width > maxViewSize || m.Height > maxViewSize {
+		return fmt.Errorf("width and height must be at most %d", maxViewSize)
+	}
+	return nil
+}
+
+// clientView is what one client can see: its viewport and the players last
+// sent to it as in view.
+type clientView struct {
+	centerX, centerY int
+	width, height    int
+	visible          map[int]struct{}
+}
+
+func (h *Hub) registerViewHandlers() {
+	h.Handle("view", func() Payload { return &ViewMessage{} }, h.handleView)
+}
+
+func (h *Hub) handleView(req *Request) error {
+	m := req.Payload.(*ViewMessage)
+	c := req.Client
+	c.mu.Lock()
+	c.view.centerX, c.view.centerY = m.X, m.Y
+	c.view.width, c.view.height = m.Width, m.Height
+	c.mu.Unlock()
+	return nil
+}
+
+// viewRect is the area c receives updates for: its viewport around the
+// player it controls, or around its camera, plus ViewMargin on every side.
+func (h *Hub) viewRect(c *Client) rect {
+	c.mu.Lock()
+	cx, cy, w, ht, playerID := c.view.centerX, c.view.centerY, c.view.width, c.view.height, c.playerID
+	c.mu.Unlock()
+	if p, ok := h.world.get(playerID); ok {
+		cx, cy = p.X+playerSize/2, p.Y+playerSize/2
+	}
+	m := h.config.ViewMargin
+	return rect{
+		minX: cx - w/2 - m - playerSize,
+		minY: cy - ht/2 - m - playerSize,
+		maxX: cx + w/2 + m,
+		maxY: cy + ht/2 + m,
+	}
+}
+
+// visiblePlayers returns the players in c's view and makes them the set c
+// is known to have.
+func (h *Hub) visiblePlayers(c *Client) []Player {
+	players := h.world.within(h.viewRect(c))
+	visible := make(map[int]struct{}, len(players))
+	for _, p := range players {
+		visible[p.ID] = struct{}{}
+	}
+	c.mu.Lock()
+	c.view.visible = visible
+	c.mu.Unlock()
+	return players
+}
+
+// sendViews brings every client's view up to date after a tick: players
+// that came into view are sent whole, players that left are announced, and
+// only the moves of players still in view are delivered.
+func (h *Hub) sendViews(tick uint64, moves []PlayerPosition) {
+	h.lock.Lock()
+	clients := make([]*Client, 0, len(h.clients))
+	for c := range h.clients {
+		clients = append(clients, c)
+	}
+	h.lock.Unlock()
+
+	for _, c := range clients {
+		players := h.world.within(h.viewRect(c))
+		visible := make(map[int]struct{}, len(players))
+
+		c.mu.Lock()
+		previous := c.view.visible
+		c.view.visible = visible
+		c.mu.Unlock()
+
+		for _, p := range players {
+			visible[p.ID] = struct{}{}
+			if _, ok := previous[p.ID]; !ok {
+				c.Send(WSMessage{Type: "player_enter_view", Data: p})
+			}
+		}
+		for id := range previous {
+			if _, ok := visible[id]; !ok {
+				c.Send(WSMessage{Type: "player_leave_view", Data: map[string]interface{}{"id": id}})
+			}
+		}
+
+		var seen []PlayerPosition
+		for _, m := range moves {
+			_, now := visible[m.ID]
+			_, before := previous[m.ID]
+			if now && before {
+				seen = append(seen, m)
+			}
+		}
+		if len(seen) > 0 {
+			c.Send(WSMessage{Type: "tick", Data: TickDelta{Tick: tick, Moves: seen}})
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package handler
+
+import "testing"
+
+// viewEvents returns the IDs c was told entered and left its view, and the
+// moves it was sent.
+func viewEvents(c *Client) (entered, left []int, moves []PlayerPosition) {
+	for _, msg := range c.queue.pop(1 << 10) {
+		switch msg.Type {
+		case "player_enter_view":
+			entered = append(entered, msg.Data.(Player).ID)
+		case "player_leave_view":
+			left = append(left, msg.Data.(map[string]interface{})["id"].(int))
+		case "tick":
+			moves = append(moves, msg.Data.(TickDelta).Moves...)
+		}
+	}
+	return entered, left, moves
+}
+
+func TestPlayersEnterAndLeaveTheView(t *testing.T) {
+	h, _, ids := newRelayedHubs(t, "watcher", "walker")
+	watcher, walker := ids[0], ids[1]
+	h.config.ViewMargin = 0
+	c := addTestClient(t, h, 1)
+	c.controlPlayer(watcher)
+	c.view = clientView{width: 400, height: 400}
+	h.world.place(PlayerPosition{ID: walker, X: 2000, Y: 2000})
+
+	h.step()
+	if entered, _, _ := viewEvents(c); len(entered) != 1 || entered[0] != watcher {
+		t.Fatalf("entered = %v, want only the watcher's own player", entered)
+	}
+
+	h.world.place(PlayerPosition{ID: walker, X: 100, Y: 100})
+	h.step()
+	if entered, left, moves := viewEvents(c); len(entered) != 1 || entered[0] != walker || len(left) != 0 || len(moves) != 0 {
+		t.Fatalf("walking into view: entered %v, left %v, moves %v", entered, left, moves)
+	}
+
+	h.world.place(PlayerPosition{ID: walker, X: 120, Y: 100})
+	h.step()
+	if entered, left, moves := viewEvents(c); len(entered) != 0 || len(left) != 0 || len(moves) != 1 || moves[0].X != 120 {
+		t.Fatalf("moving in view: entered %v, left %v, moves %v", entered, left, moves)
+	}
+
+	h.world.place(PlayerPosition{ID: walker, X: 2000, Y: 100})
+	h.step()
+	if entered, left, moves := viewEvents(c); len(entered) != 0 || len(left) != 1 || left[0] != walker || len(moves) != 0 {
+		t.Fatalf("walking out of view: entered %v, left %v, moves %v", entered, left, moves)
+	}
+
+	h.world.place(PlayerPosition{ID: walker, X: 2100, Y: 100})
+	h.step()
+	if entered, left, moves := viewEvents(c); len(entered)+len(left)+len(moves) != 0 {
+		t.Fatalf("moving out of view: entered %v, left %v, moves %v", entered, left, moves)
+	}
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// StatusSlowConsumer closes connections that can't keep up with their room.
+// Clients should reconnect with their resume token.
+const StatusSlowConsumer websocket.StatusCode = 4008
+
+// backpressureMetrics is published at /debug/vars.
+var backpressureMetrics = expvar.NewMap("ws_backpressure")
+
+// controlMessages jump ahead of queued room events: they answer the client's
+// own requests or set up its connection.
+var controlMessages = map[string]bool{
+	"welcome":    true,
+	"error":      true,
+	"correction": true,
+	"snapshot":   true,
+	"created":    true,
+	"players":    true,
+}
+
+// BackpressurePolicy decides what happens to a client that reads slower than
+// its room produces messages.
+type BackpressurePolicy struct {
+	// QueueLimit is how many messages may wait before the client counts as
+	// slow. Back-to-back tick deltas are always merged, so moves alone never
+	// add up.
+	QueueLimit int
+
+	// QueueMax is how many may wait before the client is closed at once.
+	QueueMax int
+
+	// Grace is how long a client may stay over QueueLimit before it is closed.
+	Grace time.Duration
+}
+
+// sendQueue holds a client's outgoing messages. Control messages go out
+// before events; a tick delta still at the back of the queue absorbs the next
+// one, so ordering against other events is kept.
+type sendQueue struct {
+	policy BackpressurePolicy
+	clock  Clock
+	onSlow func(reason string)
+
+	mu        sync.Mutex
+	control   []WSMessage
+	events    []WSMessage
+	slowSince time.Time
+	closed    bool
+	overflown bool // closed by the policy, not by the client leaving
+	ready     chan struct{}
+}
+
+func newSendQueue(policy BackpressurePolicy, clock Clock, onSlow func(reason string)) *sendQueue {
+	return &sendQueue{policy: policy, clock: clock, onSlow: onSlow, ready: make(chan struct{}, 1)}
+}
+
+// push queues msg, reporting false if it was dropped. Only messages lost to
+// a slow client count as dropped; a client that left just isn't sent any.
+func (q *sendQueue) push(msg WSMessage) bool {
+	q.mu.Lock()
+	if q.closed {
+		overflown := q.overflown
+		q.mu.Unlock()
+		if overflown {
+			backpressureMetrics.Add("dropped", 1)
+		}
+		return false
+	}
+
+	switch {
+	case controlMessages[msg.Type]:
+		q.control = append(q.control, msg)
+	case msg.Type == "tick" && len(q.events) > 0 && q.events[len(q.events)-1].Type == "tick":
+		last := len(q.events) - 1
+		q.events[last] = mergeTicks(q.events[last], msg)
+		backpressureMetrics.Add("coalesced", 1)
+	default:
+		q.events = append(q.events, msg)
+	}
+
+	reason := q.checkSlow()
+	q.mu.Unlock()
+
+	q.signal()
+	if reason != "" {
+		q.onSlow(reason)
+	}
+	return true
+}
+
+// checkSlow applies the policy after a push, closing the queue and returning
+// why if the client has to go. Callers hold q.mu.
+func (q *sendQueue) checkSlow() string {
+	n := len(q.control) + len(q.events)
+	reason := ""
+	switch {
+	case n <= q.policy.QueueLimit:
+		q.slowSince = time.Time{}
+		return ""
+	case n > q.policy.QueueMax:
+		reason = "send queue full"
+	case q.slowSince.IsZero():
+		q.slowSince = q.clock.Now()
+		return ""
+	case q.clock.Now().Sub(q.slowSince) >= q.policy.Grace:
+		reason = "too slow to keep up"
+	default:
+		return ""
+	}
+
+	backpressureMetrics.Add("slow_closed", 1)
+	backpressureMetrics.Add("dropped", int64(n))
+	q.closed, q.overflown = true, true
+	q.control, q.events = nil, nil
+	return reason
+}
+
+// pop takes up to n messages, control messages first.
+func (q *sendQueue) pop(n int) []WSMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var msgs []WSMessage
+	for _, queue := range []*[]WSMessage{&q.control, &q.events} {
+		take := min(n-len(msgs), len(*queue))
+		msgs = append(msgs, (*queue)[:take]...)
+		*queue = (*queue)[take:]
+	}
+	return msgs
+}
+
+// close stops the queue; the writer exits once it sees it.
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *sendQueue) isClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+func (q *sendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// mergeTicks folds the moves of next into queued, later positions winning.
+// The queued delta is shared with other clients, so a new one is built.
+func mergeTicks(queued, next WSMessage) WSMessage {
+	older, newer := queued.Data.(TickDelta), next.Data.(TickDelta)
+	index := make(map[int]int, len(older.Moves)+len(newer.Moves))
+	moves := make([]PlayerPosition, 0, len(older.Moves)+len(newer.Moves))
+	for _, list := range [][]PlayerPosition{older.Moves, newer.Moves} {
+		for _, m := range list {
+			if i, ok := index[m.ID]; ok {
+				moves[i] = m
+				continue
+			}
+			index[m.ID] = len(moves)
+			moves = append(moves, m)
+		}
+	}
+	next.Data = TickDelta{Tick: newer.Tick, Moves: moves}
+	next.shared = nil // this client's merge isn't the broadcast others got
+	return next
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+func backpressureCount(name string) int64 {
+	if v, ok := backpressureMetrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestClientThatNeverReadsIsClosed(t *testing.T) {
+	h, _, _ := newRelayedHubs(t)
+	h.config.Backpressure = BackpressurePolicy{QueueLimit: 2, QueueMax: 4, Grace: time.Minute}
+	conn, closed := testConn(t)
+	c := &Client{conn: conn, session: &Session{AccountID: 1}}
+	c.queue = h.newQueue(c)
+	slowClosed, dropped := backpressureCount("slow_closed"), backpressureCount("dropped")
+
+	for i := 0; i < 5; i++ {
+		if !c.queue.push(WSMessage{Type: "chat"}) {
+			t.Fatalf("message %d dropped before the queue was full", i)
+		}
+	}
+	if c.queue.push(WSMessage{Type: "chat"}) {
+		t.Error("a full queue took another message")
+	}
+
+	if err := <-closed; websocket.CloseStatus(err) != StatusSlowConsumer {
+		t.Errorf("client closed with %v, want %d", err, StatusSlowConsumer)
+	}
+	if n := backpressureCount("slow_closed") - slowClosed; n != 1 {
+		t.Errorf("slow_closed went up by %d, want 1", n)
+	}
+	// The five queued when it overflowed and the one pushed after.
+	if n := backpressureCount("dropped") - dropped; n != 6 {
+		t.Errorf("dropped went up by %d, want 6", n)
+	}
+}
+
+func TestClientOverQueueLimitIsClosedAfterGrace(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	var reasons []string
+	q := newSendQueue(BackpressurePolicy{QueueLimit: 2, QueueMax: 100, Grace: 5 * time.Second}, clock,
+		func(reason string) { reasons = append(reasons, reason) })
+
+	for i := 0; i < 3; i++ {
+		q.push(WSMessage{Type: "chat"})
+	}
+	clock.Advance(5*time.Second - time.Millisecond)
+	q.push(WSMessage{Type: "chat"})
+	if len(reasons) != 0 {
+		t.Fatalf("closed within the grace period: %v", reasons)
+	}
+
+	clock.Advance(time.Millisecond)
+	q.push(WSMessage{Type: "chat"})
+	if len(reasons) != 1 || reasons[0] != "too slow to keep up" {
+		t.Errorf("reasons = %v, want one close for being too slow", reasons)
+	}
+}
+
+func TestClientsThatLeaveDontCountAsDrops(t *testing.T) {
+	q := newSendQueue(DefaultHubConfig().Backpressure, RealClock{}, func(string) {})
+	q.push(WSMessage{Type: "chat"})
+	q.close()
+	dropped := backpressureCount("dropped")
+
+	if q.push(WSMessage{Type: "chat"}) {
+		t.Error("a closed queue took a message")
+	}
+	if n := backpressureCount("dropped") - dropped; n != 0 {
+		t.Errorf("dropped went up by %d after the client left", n)
+	}
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxChatLength    = 500
+	chatHistoryLimit = 50
+)
+
+// ChatSendMessage is a client's chat line. Shouts reach the whole room;
+// everything else only reaches players within the hub's chat radius.
+type ChatSendMessage struct {
+	Text  string `json:"text"`
+	Shout bool   `json:"shout"`
+}
+
+func (m *ChatSendMessage) Validate() error {
+	m.Text = strings.TrimSpace(m.Text)
+	if m.Text == "" {
+		return errors.New("text is required")
+	}
+	if utf8.RuneCountInString(m.Text) > maxChatLength {
+		return fmt.Errorf("text must be at most %d characters", maxChatLength)
+	}
+	return nil
+}
+
+// ChatMessage is a delivered chat line.
+type ChatMessage struct {
+	ID        int64     `json:"id"`
+	PlayerID  int       `json:"playerId"`
+	Name      string    `json:"name"`
+	Text      string    `json:"text"`
+	Shout     bool      `json:"shout"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Where the speaker stood, for working out who could hear it.
+	x, y int
+}
+
+func (h *Hub) registerChatHandlers() {
+	h.Handle("chat", func() Payload { return &ChatSendMessage{} }, h.handleChat)
+}
+
+func (h *Hub) handleChat(req *Request) error {
+	m := req.Payload.(*ChatSendMessage)
+	speaker, ok := h.world.get(req.Client.ControlledPlayer())
+	if !ok {
+		return &MessageError{Code: ErrCodeForbidden, Message: "control a player to chat"}
+	}
+
+	msg := ChatMessage{PlayerID: speaker.ID, Name: speaker.Name, Text: m.Text, Shout: m.Shout, x: speaker.X, y: speaker.Y}
+	if err := h.store.Chat.Add(h.room.ID, req.Client.AccountID(), &msg); err != nil {
+		return fmt.Errorf("saving chat message: %w", err)
+	}
+
+	if msg.Shout {
+		h.Broadcast(WSMessage{Type: "chat", Data: msg})
+		return nil
+	}
+	h.sayNearby(msg, req.Client)
+	h.publish(roomEvent{Chat: &nearbyChat{Message: msg, X: msg.x, Y: msg.y}})
+	return nil
+}
+
+// sayNearby sends msg to the local clients that can hear it, and to speaker
+// wherever it stands.
+func (h *Hub) sayNearby(msg ChatMessage, speaker *Client) {
+	out := WSMessage{Type: "chat", Data: msg}
+	h.sendWhere(out, func(c *Client) bool {
+		if c != speaker && !h.canHear(c, msg) {
+			return false
+		}
+		c.hear(msg.ID)
+		return true
+	})
+}
+
+// hear notes that c is caught up with the chat up to message id.
+func (c *Client) hear(id int64) {
+	c.mu.Lock()
+	c.heardChat = max(c.heardChat, id)
+	c.mu.Unlock()
+}
+
+func (c *Client) lastHeard() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.heardChat
+}
+
+// nearbyChat is a chat message relayed to other instances along with where
+// it was said, which ChatMessage leaves out of its JSON.
+type nearbyChat struct {
+	Message ChatMessage `json:"message"`
+	X       int         `json:"x"`
+	Y       int         `json:"y"`
+}
+
+func (c *nearbyChat) message() ChatMessage {
+	msg := c.Message
+	msg.x, msg.y = c.X, c.Y
+	return msg
+}
+
+// canHear reports whether c's player is close enough to hear msg.
+func (h *Hub) canHear(c *Client, msg ChatMessage) bool {
+	if msg.Shout {
+		return true
+	}
+	listener, ok := h.world.get(c.ControlledPlayer())
+	if !ok {
+		return false
+	}
+	return withinRadius(listener.X, listener.Y, msg.x, msg.y, h.config.ChatRadius)
+}
+
+func withinRadius(x1, y1, x2, y2 int, radius float64) bool {
+	return math.Hypot(float64(x1-x2), float64(y1-y2)) <= radius
+}
+
+// sendChatHistory sends c the room's recent chat it would have been able to
+// hear from where its player stands now.
+func (h *Hub) sendChatHistory(c *Client) {
+	if history, ok := h.recentChat(c, 0, true); ok {
+		c.Send(WSMessage{Type: "chat_history", Data: history})
+	}
+}
+
+// sendMissedChat sends a resumed client, as live chat, what was said near it
+// while it was away. Shouts were broadcast, so the replay already has them.
+func (h *Hub) sendMissedChat(c *Client) {
+	missed, _ := h.recentChat(c, c.lastHeard(), false)
+	for _, msg := range missed {
+		c.Send(WSMessage{Type: "chat", Data: msg})
+	}
+}
+
+// recentChat returns the room's recent chat after message after that c can
+// hear, oldest first, and marks c as caught up with all of it.
+func (h *Hub) recentChat(c *Client, after int64, shouts bool) ([]ChatMessage, bool) {
+	recent, err := h.store.Chat.Recent(h.room.ID, chatHistoryLimit)
+	if err != nil {
+		log.Printf("❌ Error loading chat history for room %q: %v", h.room.Slug, err)
+		return nil, false
+	}
+	if len(recent) > 0 {
+		c.hear(recent[0].ID)
+	}
+
+	history := []ChatMessage{}
+	for _, msg := range recent {
+		if msg.ID > after && (shouts || !msg.Shout) && h.canHear(c, msg) {
+			history = append(history, msg)
+		}
+	}
+	// Oldest first, like live messages arrive.
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	return history, true
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClockTickers(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	ticker := clock.NewTicker(time.Second)
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked early")
+	default:
+	}
+	clock.Advance(time.Millisecond)
+	select {
+	case at := <-ticker.C():
+		if !at.Equal(time.Unix(1, 0)) {
+			t.Errorf("ticked at %v", at)
+		}
+	default:
+		t.Fatal("didn't tick")
+	}
+	ticker.Stop()
+	clock.Advance(time.Hour)
+	select {
+	case <-ticker.C():
+		t.Error("stopped ticker ticked")
+	default:
+	}
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"nhooyr.io/websocket"
+)
+
+// ProtocolMsgpack carries the same messages as ProtocolJSON, MessagePack
+// encoded in binary frames. Field names are the JSON ones, except that tick
+// delta moves are [id, x, y] arrays.
+const ProtocolMsgpack = "ourgatther.msgpack.v1"
+
+// codec turns messages into frames and back for one subprotocol.
+type codec interface {
+	frameType() websocket.MessageType
+	encode(msg WSMessage) ([]byte, error)
+	encodeBatch(frames [][]byte) ([]byte, error)
+	decodeEnvelope(raw []byte) (inboundMessage, *MessageError)
+	decodePayload(data []byte, newPayload func() Payload) (Payload, error)
+}
+
+// codecFor returns the codec for a negotiated subprotocol, which keeps the
+// client's casing. Clients that negotiated nothing speak JSON.
+func codecFor(subprotocol string) codec {
+	if strings.EqualFold(subprotocol, ProtocolMsgpack) {
+		return msgpackCodec{}
+	}
+	return jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) frameType() websocket.MessageType { return websocket.MessageText }
+
+func (jsonCodec) encode(msg WSMessage) ([]byte, error) { return json.Marshal(msg) }
+
+func (jsonCodec) decodeEnvelope(raw []byte) (inboundMessage, *MessageError) {
+	return decodeEnvelope(raw)
+}
+
+func (jsonCodec) decodePayload(data []byte, newPayload func() Payload) (Payload, error) {
+	return decodePayload(data, newPayload)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) frameType() websocket.MessageType { return websocket.MessageBinary }
+
+func (msgpackCodec) encode(msg WSMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	enc.UseCompactFloats(true)
+	if err := enc.Encode(msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) decodeEnvelope(raw []byte) (inboundMessage, *MessageError) {
+	var env struct {
+		Type string             `msgpack:"type"`
+		Data msgpack.RawMessage `msgpack:"data"`
+	}
+	if err := msgpack.Unmarshal(raw, &env); err != nil {
+		return inboundMessage{}, &MessageError{Code: ErrCodeBadJSON, Message: err.Error()}
+	}
+	if env.Type == "" {
+		return inboundMessage{}, &MessageError{Code: ErrCodeInvalidPayload, Message: "type is required"}
+	}
+	return inboundMessage{Type: env.Type, Data: []byte(env.Data)}, nil
+}
+
+// decodePayload is as strict as the JSON one: unknown fields and trailing
+// data are rejected.
+func (msgpackCodec) decodePayload(data []byte, newPayload func() Payload) (Payload, error) {
+	payload := newPayload()
+	if len(data) > 0 && data[0] != msgpackNil {
+		r := bytes.NewReader(data)
+		dec := msgpack.NewDecoder(r)
+		dec.SetCustomStructTag("json")
+		dec.DisallowUnknownFields(true)
+		if err := dec.Decode(payload); err != nil {
+			return nil, err
+		}
+		if r.Len() > 0 {
+			return nil, errors.New("unexpected data after payload")
+		}
+	}
+	if err := payload.Validate(); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+const msgpackNil = 0xc0
+
+// EncodeMsgpack writes a tick delta move as [id, x, y]; moves are most of
+// the traffic, and this keeps each one to a few bytes.
+func (p PlayerPosition) EncodeMsgpack(enc *msgpack.Encoder) error {
+	if err := enc.EncodeArrayLen(3); err != nil {
+		return err
+	}
+	for _, v := range []int{p.ID, p.X, p.Y} {
+		if err := enc.EncodeInt(int64(v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"testing"
+
+	"nhooyr.io/websocket"
+)
+
+func TestCodecFor(t *testing.T) {
+	for _, tt := range []struct {
+		subprotocol string
+		want        websocket.MessageType
+	}{
+		{"", websocket.MessageText},
+		{ProtocolJSON, websocket.MessageText},
+		{ProtocolMsgpack, websocket.MessageBinary},
+		{"OurGatther.MsgPack.V1", websocket.MessageBinary},
+	} {
+		if got := codecFor(tt.subprotocol).frameType(); got != tt.want {
+			t.Errorf("codecFor(%q) sends %v frames, want %v", tt.subprotocol, got, tt.want)
+		}
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for name, c := range map[string]codec{"json": jsonCodec{}, "msgpack": msgpackCodec{}} {
+		t.Run(name, func(t *testing.T) {
+			want := MoveMessage{ID: 3, X: -40, Y: 5000}
+			raw, err := c.encode(WSMessage{Type: "move", Data: want})
+			if err != nil {
+				t.Fatal(err)
+			}
+			in, msgErr := c.decodeEnvelope(raw)
+			if msgErr != nil {
+				t.Fatal(msgErr)
+			}
+			if in.Type != "move" {
+				t.Errorf("type = %q, want move", in.Type)
+			}
+			payload, err := c.decodePayload(in.Data, func() Payload { return &MoveMessage{} })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := *payload.(*MoveMessage); got != want {
+				t.Errorf("decoded %+v, want %+v", got, want)
+			}
+		})
+	}
+}
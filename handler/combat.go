@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+const (
+	bulletDamage = 10
+	medkitHeal   = 15
+
+	// Players are 60x60 squares positioned by their top-left corner.
+	playerSize = 60
+
+	// Projectiles fly this far past the point they were aimed at.
+	projectileOvershoot = 300
+)
+
+func (h *Hub) registerCombatHandlers() {
+	fromControlled := requireControl(func(p Payload) int { return p.(*SpawnMessage).FromID })
+	h.Handle("spawn_bullet", func() Payload { return &SpawnMessage{} }, h.handleSpawn, fromControlled)
+	h.Handle("spawn_medkit", func() Payload { return &SpawnMessage{} }, h.handleSpawn, fromControlled)
+}
+
+// handleSpawn fires a bullet or med kit from the client's player towards the
+// target. The projectile simulation decides what, if anything, it hits.
+func (h *Hub) handleSpawn(req *Request) error {
+	m := req.Payload.(*SpawnMessage)
+
+	shooter, ok := h.world.get(m.FromID)
+	if !ok {
+		return errPlayerNotFound
+	}
+	kind := ProjectileBullet
+	if req.Type == "spawn_medkit" {
+		kind = ProjectileMedkit
+	}
+	h.projectiles.Spawn(kind, shooter, m.TargetX, m.TargetY)
+	return nil
+}
+
+// handleProjectileHit applies a projectile's effect to the player it hit.
+func (h *Hub) handleProjectileHit(hit ProjectileHit) {
+	delta := -bulletDamage
+	if hit.Projectile.Kind == ProjectileMedkit {
+		delta = medkitHeal
+	}
+	if err := h.changeHealth(hit.PlayerID, delta, hit.Projectile.FromID); err != nil {
+		log.Printf("❌ Error applying %s hit on player %d: %v", hit.Projectile.Kind, hit.PlayerID, err)
+	}
+}
+
+// firstHitAlong returns the first player other than fromID whose hitbox the
+// segment from (x, y) to (x+dx, y+dy) enters, and the fraction of the segment
+// travelled before it does. It returns 0 if the segment hits nobody.
+func firstHitAlong(fromID int, x, y, dx, dy float64, players []Player) (int, float64) {
+	hit, best := 0, math.Inf(1)
+	for _, p := range players {
+		if p.ID == fromID {
+			continue
+		}
+		t, ok := segmentHitsBox(x, y, dx, dy,
+			float64(p.X), float64(p.Y), float64(p.X+playerSize), float64(p.Y+playerSize))
+		if ok && t < best {
+			hit, best = p.ID, t
+		}
+	}
+	return hit, best
+}
+
+// segmentHitsBox reports whether the segment from (x, y) to (x+dx, y+dy)
+// crosses the box, and at which fraction t of the segment it enters it.
+func segmentHitsBox(x, y, dx, dy, minX, minY, maxX, maxY float64) (float64, bool) {
+	tMin, tMax := 0.0, 1.0
+	for _, axis := range [2][4]float64{{x, dx, minX, maxX}, {y, dy, minY, maxY}} {
+		origin, delta, lo, hi := axis[0], axis[1], axis[2], axis[3]
+		if delta == 0 {
+			if origin < lo || origin > hi {
+				return 0, false
+			}
+			continue
+		}
+		t1, t2 := (lo-origin)/delta, (hi-origin)/delta
+		if t1 > t2 {
+			t1, t2 = t2, t1
+		}
+		tMin, tMax = math.Max(tMin, t1), math.Min(tMax, t2)
+		if tMin > tMax {
+			return 0, false
+		}
+	}
+	return tMin, true
+}
+
+// changeHealth applies delta to a player's stored health, broadcasts the new
+// value, and kills the player if it drops to zero.
+func (h *Hub) changeHealth(playerID, delta, fromID int) error {
+	health, err := h.store.Players.ChangeHealth(playerID, delta, maxHealth)
+	if err != nil {
+		return fmt.Errorf("changing health of player %d: %w", playerID, err)
+	}
+
+	h.world.update(playerID, func(p *Player) { p.Health = health })
+	h.publishPlayer(playerID)
+
+	changeType := "heal"
+	if delta < 0 {
+		changeType = "damage"
+	}
+	log.Printf("🩸 Player %d %s by player %d, health now %d", playerID, changeType, fromID, health)
+
+	h.Broadcast(WSMessage{
+		Type: "health_change",
+		Data: map[string]interface{}{
+			"playerId": playerID,
+			"health":   health,
+			"type":     changeType,
+			"fromId":   fromID,
+		},
+	})
+
+	if health > 0 {
+		return nil
+	}
+	log.Printf("💀 Player %d was killed by player %d", playerID, fromID)
+	return h.deletePlayer(playerID, map[string]interface{}{"id": playerID, "reason": "killed", "killerId": fromID})
+}
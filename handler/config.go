@@ -0,0 +1,113 @@
+package handler
+
+import "time"
+
+// HubConfig tunes the hub's simulation.
+type HubConfig struct {
+	// InstanceID identifies this server among the instances sharing rooms
+	// through a PubSub. It must be unique per process.
+	InstanceID string
+
+	// TickRate is the number of simulation ticks per second. Inputs are
+	// applied as they arrive but only sent out once per tick.
+	TickRate int
+
+	// WorldWidth and WorldHeight bound player positions, in pixels.
+	WorldWidth  int
+	WorldHeight int
+
+	// MaxSpeed is the fastest a player may move, in pixels per second.
+	MaxSpeed float64
+
+	// Clients only get updates for players within their view: ViewWidth by
+	// ViewHeight around their player (or camera, if they set a view and
+	// control no player), widened by ViewMargin on each side so players are
+	// sent a little before they come on screen. Players are indexed in a
+	// grid of GridCellSize pixel cells to find them.
+	ViewWidth    int
+	ViewHeight   int
+	ViewMargin   int
+	GridCellSize int
+
+	// PositionFlushInterval is how often moved players' positions are
+	// written to the database. Positions are also saved when their client
+	// disconnects and when the hub closes.
+	PositionFlushInterval time.Duration
+
+	// ChatRadius is how far, in pixels, a normal chat message carries.
+	ChatRadius float64
+
+	// RTCRadius is how close, in pixels, two players must get before they
+	// are told to open a call.
+	RTCRadius float64
+
+	// AwayAfter is how long an online account may go without moving before
+	// it shows as away.
+	AwayAfter time.Duration
+
+	// ReplayBuffer is how many recent broadcasts each room keeps, and
+	// ResumeGrace how long a dropped client may reconnect and get the ones it
+	// missed instead of a fresh snapshot.
+	ReplayBuffer int
+	ResumeGrace  time.Duration
+
+	// Backpressure handles clients that can't keep up. QueueMax should be
+	// above ReplayBuffer so a resumed client isn't closed by its own replay.
+	Backpressure BackpressurePolicy
+
+	// Clients are pinged every PingInterval and closed if the pong takes
+	// longer than PongTimeout, a write longer than WriteTimeout, or nothing
+	// at all arrives for ReadIdleTimeout.
+	PingInterval    time.Duration
+	PongTimeout     time.Duration
+	WriteTimeout    time.Duration
+	ReadIdleTimeout time.Duration
+
+	// Clock is the hub's source of time; nil means the wall clock. Tests
+	// pass a FakeClock.
+	Clock Clock
+
+	// Upgrade decides which connections are accepted at all.
+	Upgrade UpgradeConfig
+
+	// BatchWindow is how long a client's writer waits for more messages to
+	// send in the same frame, up to MaxBatch of them. Zero sends each
+	// message as soon as it is queued.
+	BatchWindow time.Duration
+	MaxBatch    int
+}
+
+func DefaultHubConfig() HubConfig {
+	return HubConfig{
+		InstanceID:            NewInstanceID(),
+		TickRate:              20,
+		WorldWidth:            10000,
+		WorldHeight:           10000,
+		MaxSpeed:              600,
+		ViewWidth:             1920,
+		ViewHeight:            1080,
+		ViewMargin:            400,
+		GridCellSize:          500,
+		PositionFlushInterval: time.Second,
+		ChatRadius:            300,
+		RTCRadius:             250,
+		AwayAfter:             5 * time.Minute,
+		ReplayBuffer:          1024,
+		ResumeGrace:           30 * time.Second,
+		Backpressure: BackpressurePolicy{
+			QueueLimit: 128,
+			QueueMax:   2048,
+			Grace:      5 * time.Second,
+		},
+		PingInterval:    20 * time.Second,
+		PongTimeout:     10 * time.Second,
+		WriteTimeout:    10 * time.Second,
+		ReadIdleTimeout: 60 * time.Second,
+		Upgrade: UpgradeConfig{
+			Subprotocols:   []string{ProtocolMsgpack, ProtocolJSON},
+			MaxMessageSize: 1 << 20, // drawings are sent as base64 images
+		},
+		BatchWindow: 5 * time.Millisecond,
+		MaxBatch:    64,
+	}
+}
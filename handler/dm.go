@@ -0,0 +1,312 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultDMPageSize = 50
+	maxDMPageSize     = 200
+)
+
+// DirectMessage is a private message between two accounts.
+type DirectMessage struct {
+	ID          int64      `json:"id"`
+	SenderID    int        `json:"senderId"`
+	RecipientID int        `json:"recipientId"`
+	Text        string     `json:"text"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ReadAt      *time.Time `json:"readAt"`
+}
+
+// DMReceipt tells both sides of a conversation that ReaderID has read every
+// message from SenderID up to UpTo.
+type DMReceipt struct {
+	ReaderID int       `json:"readerId"`
+	SenderID int       `json:"senderId"`
+	UpTo     int64     `json:"upTo"`
+	ReadAt   time.Time `json:"readAt"`
+}
+
+// DMUnread counts one sender's unread messages, sent on connect.
+type DMUnread struct {
+	From  int `json:"from"`
+	Count int `json:"count"`
+}
+
+type SendDMMessage struct {
+	To   int    `json:"to"`
+	Text string `json:"text"`
+}
+
+func (m *SendDMMessage) Validate() error {
+	if err := validateID("to", m.To); err != nil {
+		return err
+	}
+	m.Text = strings.TrimSpace(m.Text)
+	if m.Text == "" {
+		return errors.New("text is required")
+	}
+	if utf8.RuneCountInString(m.Text) > maxChatLength {
+		return fmt.Errorf("text must be at most %d characters", maxChatLength)
+	}
+	return nil
+}
+
+type ReadDMMessage struct {
+	From int   `json:"from"`
+	UpTo int64 `json:"upTo"`
+}
+
+func (m *ReadDMMessage) Validate() error {
+	if err := validateID("from", m.From); err != nil {
+		return err
+	}
+	if m.UpTo <= 0 {
+		return errors.New("upTo must be a positive integer")
+	}
+	return nil
+}
+
+// DirectMessenger stores direct messages and delivers them to every
+// connected client of an account, whichever room it is in. Once relayed, it
+// also reaches the clients connected to other instances.
+type DirectMessenger struct {
+	store MessageStore
+
+	mu       sync.Mutex
+	clients  map[int]map[*Client]struct{} // account ID -> connected clients
+	bus      PubSub
+	instance string
+}
+
+// dmChannel carries direct messages and read receipts between instances.
+const dmChannel = "ourgatther_dms"
+
+// dmEvent is a message for the clients of some accounts, published for the
+// ones connected to other instances.
+type dmEvent struct {
+	Instance string    `json:"instance"`
+	To       []int     `json:"to"`
+	Message  WSMessage `json:"message"`
+}
+
+func NewDirectMessenger(store MessageStore) *DirectMessenger {
+	return &DirectMessenger{store: store, clients: make(map[int]map[*Client]struct{})}
+}
+
+func (d *DirectMessenger) connect(c *Client) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.clients[c.AccountID()] == nil {
+		d.clients[c.AccountID()] = make(map[*Client]struct{})
+	}
+	d.clients[c.AccountID()][c] = struct{}{}
+}
+
+func (d *DirectMessenger) disconnect(c *Client) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.clients[c.AccountID()], c)
+	if len(d.clients[c.AccountID()]) == 0 {
+		delete(d.clients, c.AccountID())
+	}
+}
+
+// Relay publishes what d sends to the other instances on bus and delivers
+// what they send to this instance's clients, until unsubscribe is called.
+func (d *DirectMessenger) Relay(bus PubSub, instanceID string) (unsubscribe func(), err error) {
+	d.mu.Lock()
+	d.bus, d.instance = bus, instanceID
+	d.mu.Unlock()
+	unsubscribe, err = bus.Subscribe(dmChannel, d.receive)
+	if err != nil {
+		return nil, fmt.Errorf("subscribing to direct messages: %w", err)
+	}
+	return unsubscribe, nil
+}
+
+// deliver sends msg to every client of the accounts, here and, when relayed,
+// on the other instances.
+func (d *DirectMessenger) deliver(msg WSMessage, accounts ...int) {
+	d.deliverLocal(msg, accounts)
+	d.mu.Lock()
+	bus, instance := d.bus, d.instance
+	d.mu.Unlock()
+	if bus == nil {
+		return
+	}
+	payload, err := json.Marshal(dmEvent{Instance: instance, To: accounts, Message: msg})
+	if err == nil {
+		err = bus.Publish(dmChannel, payload)
+	}
+	if err != nil {
+		log.Printf("❌ Publishing a %s for accounts %v: %v", msg.Type, accounts, err)
+	}
+}
+
+// deliverLocal sends msg to every client of the accounts connected here.
+func (d *DirectMessenger) deliverLocal(msg WSMessage, accounts []int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, accountID := range accounts {
+		for c := range d.clients[accountID] {
+			c.Send(msg)
+		}
+	}
+}
+
+// receive delivers a message published by another instance.
+func (d *DirectMessenger) receive(payload []byte) {
+	var event dmEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		log.Printf("❌ Bad direct message event: %v", err)
+		return
+	}
+	d.mu.Lock()
+	own := event.Instance == d.instance
+	d.mu.Unlock()
+	if !own {
+		d.deliverLocal(event.Message, event.To)
+	}
+}
+
+// Send stores a message and delivers it to both accounts' clients, so the
+// sender's other tabs see it too.
+func (d *DirectMessenger) Send(from, to int, text string) (DirectMessage, error) {
+	if from == to {
+		return DirectMessage{}, &MessageError{Code: ErrCodeInvalidPayload, Message: "you can't message yourself"}
+	}
+	msg := DirectMessage{SenderID: from, RecipientID: to, Text: text}
+	err := d.store.Send(&msg)
+	if errors.Is(err, ErrAccountNotFound) {
+		return msg, &MessageError{Code: ErrCodeInvalidPayload, Message: fmt.Sprintf("account %d does not exist", to)}
+	}
+	if err != nil {
+		return msg, fmt.Errorf("saving direct message: %w", err)
+	}
+
+	d.deliver(WSMessage{Type: "dm", Data: msg}, to, from)
+	return msg, nil
+}
+
+// MarkRead marks every unread message from sender to reader up to upTo as
+// read and sends a receipt to both accounts.
+func (d *DirectMessenger) MarkRead(reader, sender int, upTo int64) error {
+	last, readAt, err := d.store.MarkRead(reader, sender, upTo)
+	if err != nil {
+		return fmt.Errorf("marking direct messages read: %w", err)
+	}
+	if last == 0 {
+		return nil // already read
+	}
+
+	d.deliver(WSMessage{Type: "dm_read", Data: DMReceipt{ReaderID: reader, SenderID: sender, UpTo: last, ReadAt: readAt}}, sender, reader)
+	return nil
+}
+
+// Unread counts accountID's unread messages per sender.
+func (d *DirectMessenger) Unread(accountID int) ([]DMUnread, error) {
+	return d.store.Unread(accountID)
+}
+
+// History returns up to limit messages between a and b older than before
+// (0 for the newest), newest first.
+func (d *DirectMessenger) History(a, b int, before int64, limit int) ([]DirectMessage, error) {
+	if before <= 0 {
+		before = 1<<63 - 1
+	}
+	return d.store.History(a, b, before, limit)
+}
+
+func (h *Hub) registerDMHandlers() {
+	h.Handle("dm", func() Payload { return &SendDMMessage{} }, h.handleSendDM)
+	h.Handle("dm_read", func() Payload { return &ReadDMMessage{} }, h.handleReadDM)
+}
+
+// sendUnreadDMs tells c how many messages arrived for its account while it
+// was away; the client fetches them over /messages.
+func (h *Hub) sendUnreadDMs(c *Client) {
+	unread, err := h.dms.Unread(c.AccountID())
+	if err != nil {
+		log.Printf("❌ Error counting unread messages for account %d: %v", c.AccountID(), err)
+		return
+	}
+	c.Send(WSMessage{Type: "dm_unread", Data: unread})
+}
+
+func (h *Hub) handleSendDM(req *Request) error {
+	m := req.Payload.(*SendDMMessage)
+	_, err := h.dms.Send(req.Client.AccountID(), m.To, m.Text)
+	return err
+}
+
+func (h *Hub) handleReadDM(req *Request) error {
+	m := req.Payload.(*ReadDMMessage)
+	return h.dms.MarkRead(req.Client.AccountID(), m.From, m.UpTo)
+}
+
+// DMHistoryResponse is one page of a conversation. NextCursor is passed back
+// as ?before= to fetch older messages, and is null on the last page.
+type DMHistoryResponse struct {
+	Messages   []DirectMessage `json:"messages"`
+	NextCursor *int64          `json:"nextCursor"`
+}
+
+// DirectMessagesHandler serves GET /messages?with=<accountId>&before=<id>&limit=<n>,
+// the signed-in account's conversation with another account, newest first.
+func DirectMessagesHandler(dms *DirectMessenger, sessions *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		sess, err := sessions.FromRequest(r)
+		if err != nil {
+			writeUnauthorized(w)
+			return
+		}
+
+		query := r.URL.Query()
+		with, err := strconv.Atoi(query.Get("with"))
+		if err != nil || with <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "with must be an account ID")
+			return
+		}
+		var before int64
+		if v := query.Get("before"); v != "" {
+			if before, err = strconv.ParseInt(v, 10, 64); err != nil || before <= 0 {
+				writeJSONError(w, http.StatusBadRequest, "before must be a message ID")
+				return
+			}
+		}
+		limit := defaultDMPageSize
+		if v := query.Get("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxDMPageSize {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxDMPageSize))
+				return
+			}
+		}
+
+		messages, err := dms.History(sess.AccountID, with, before, limit)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		resp := DMHistoryResponse{Messages: messages}
+		if len(messages) == limit {
+			resp.NextCursor = &messages[len(messages)-1].ID
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -1,8 +1,9 @@
 package handler
 
 import (
-	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 )
 
@@ -14,45 +15,89 @@ type DrawingPoint struct {
 	PlayerID int    `json:"player_id"`
 }
 
-func DrawHandler(db *sql.DB) http.HandlerFunc {
+// DrawHandler saves a point drawn by one of the logged-in account's players,
+// into the room that player is in.
+func DrawHandler(store *Store, sessions *SessionManager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		sess, err := sessions.FromRequest(r)
+		if err != nil {
+			writeUnauthorized(w)
+			return
+		}
+
 		var point DrawingPoint
 		if err := json.NewDecoder(r.Body).Decode(&point); err != nil {
 			http.Error(w, "Invalid input", http.StatusBadRequest)
 			return
 		}
 
-		_, err := db.Exec(`
-				INSERT INTO drawing (player_id, x, y, color, size, image)
-				VALUES ($1, $2, $3, $4, $5, '')
-			`, point.PlayerID, point.X, point.Y, point.Color, point.Size)
+		owner, err := store.Players.Owner(point.PlayerID)
+		if errors.Is(err, ErrPlayerNotFound) || (err == nil && owner != sess.AccountID) {
+			http.Error(w, "Player is not yours", http.StatusForbidden)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to save drawing", http.StatusInternalServerError)
+			return
+		}
 
+		roomID, err := roomIDFromRequest(store.Rooms, r)
+		if errors.Is(err, ErrRoomNotFound) {
+			http.Error(w, "Room not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to save drawing", http.StatusInternalServerError)
+			return
+		}
+
+		playerRoom, err := store.Players.Room(point.PlayerID)
 		if err != nil {
 			http.Error(w, "Failed to save drawing", http.StatusInternalServerError)
 			return
 		}
+		if playerRoom != roomID {
+			http.Error(w, "Player is not in this room", http.StatusForbidden)
+			return
+		}
+
+		if err := store.Drawings.AddPoint(roomID, point); err != nil {
+			http.Error(w, "Failed to save drawing", http.StatusInternalServerError)
+			return
+		}
 
 		w.WriteHeader(http.StatusCreated)
 	}
 }
 
-func GetAllDrawingsHandler(db *sql.DB) http.HandlerFunc {
+func GetAllDrawingsHandler(store *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query(`SELECT x, y, color, size FROM drawing`)
-		if err != nil {
+		roomID, err := roomIDFromRequest(store.Rooms, r)
+		if errors.Is(err, ErrRoomNotFound) {
+			http.Error(w, "Room not found", http.StatusNotFound)
+			return
+		} else if err != nil {
 			http.Error(w, "Failed to fetch drawings", http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
 
-		var drawings []DrawingPoint
-		for rows.Next() {
-			var p DrawingPoint
-			if err := rows.Scan(&p.X, &p.Y, &p.Color, &p.Size); err == nil {
-				drawings = append(drawings, p)
-			}
+		drawings, err := store.Drawings.Points(roomID)
+		if err != nil {
+			http.Error(w, "Failed to fetch drawings", http.StatusInternalServerError)
+			return
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(drawings)
 	}
 }
+
+func (h *Hub) registerDrawingHandlers() {
+	h.Handle("save_drawing", func() Payload { return &SaveDrawingMessage{} }, h.handleSaveDrawing,
+		h.requireOwner(func(p Payload) int { return p.(*SaveDrawingMessage).PlayerID }))
+}
+
+func (h *Hub) handleSaveDrawing(req *Request) error {
+	m := req.Payload.(*SaveDrawingMessage)
+	if err := h.store.Drawings.AddImage(h.room.ID, m.PlayerID, m.Image); err != nil {
+		return fmt.Errorf("saving drawing: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDrawOnlyIntoThePlayersRoom(t *testing.T) {
+	store := NewMemoryStore()
+	sessions := NewSessionManager(store.Sessions, []byte("secret"), time.Hour)
+	accountID := mustAccount(t, store, "alice")
+	lobby := mustLobby(t, store)
+	if _, err := store.Rooms.Create(Room{Slug: "attic", Name: "Attic", Capacity: 10}); err != nil {
+		t.Fatal(err)
+	}
+	playerID := mustPlayer(t, store, "alice", accountID, lobby.ID)
+	token, _, err := sessions.Issue(accountID, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	draw := DrawHandler(store, sessions)
+	body := `{"x": 1, "y": 2, "color": "red", "size": 3, "player_id": ` + strconv.Itoa(playerID) + `}`
+	for _, tt := range []struct {
+		room string
+		want int
+	}{
+		{"attic", http.StatusForbidden},
+		{DefaultRoom, http.StatusCreated},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/draw?room="+tt.room, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		draw(w, r)
+		if w.Code != tt.want {
+			t.Errorf("drawing into %q: status %d, want %d", tt.room, w.Code, tt.want)
+		}
+	}
+
+	attic, err := store.Rooms.Get("attic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if points, _ := store.Drawings.Points(attic.ID); len(points) != 0 {
+		t.Errorf("the attic has points %+v", points)
+	}
+}
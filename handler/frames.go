@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"bytes"
+	"expvar"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// frameMetrics is published at /debug/vars.
+var frameMetrics = expvar.NewMap("ws_frames")
+
+// sharedFrames holds a message's encodings, so a message sent to many
+// clients is encoded once per codec instead of once per client.
+type sharedFrames struct {
+	mu     sync.Mutex
+	frames map[codec][]byte
+}
+
+// encodeMessage encodes msg with c, reusing an earlier encoding of the same
+// broadcast when there is one.
+func encodeMessage(c codec, msg WSMessage) ([]byte, error) {
+	if msg.shared == nil {
+		frameMetrics.Add("encoded", 1)
+		return c.encode(msg)
+	}
+	msg.shared.mu.Lock()
+	defer msg.shared.mu.Unlock()
+	if data, ok := msg.shared.frames[c]; ok {
+		frameMetrics.Add("reused", 1)
+		return data, nil
+	}
+	data, err := c.encode(msg)
+	if err != nil {
+		return nil, err
+	}
+	frameMetrics.Add("encoded", 1)
+	if msg.shared.frames == nil {
+		msg.shared.frames = make(map[codec][]byte)
+	}
+	msg.shared.frames[c] = data
+	return data, nil
+}
+
+// encodeFrame encodes msgs as one frame: a lone message as itself, several
+// as a "batch" message whose data is the list of them.
+func encodeFrame(c codec, msgs []WSMessage) ([]byte, error) {
+	if len(msgs) == 1 {
+		return encodeMessage(c, msgs[0])
+	}
+	frames := make([][]byte, len(msgs))
+	for i, msg := range msgs {
+		data, err := encodeMessage(c, msg)
+		if err != nil {
+			return nil, err
+		}
+		frames[i] = data
+	}
+	frameMetrics.Add("batches", 1)
+	frameMetrics.Add("batched_messages", int64(len(msgs)))
+	return c.encodeBatch(frames)
+}
+
+// encodeBatch splices already encoded messages into a JSON batch.
+func (jsonCodec) encodeBatch(frames [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"type":"batch","data":[`)
+	buf.Write(bytes.Join(frames, []byte(",")))
+	buf.WriteString(`]}`)
+	return buf.Bytes(), nil
+}
+
+// encodeBatch splices already encoded messages into a MessagePack batch.
+func (msgpackCodec) encodeBatch(frames [][]byte) ([]byte, error) {
+	batch := struct {
+		Type string               `msgpack:"type"`
+		Data []msgpack.RawMessage `msgpack:"data"`
+	}{Type: "batch", Data: make([]msgpack.RawMessage, len(frames))}
+	for i, f := range frames {
+		batch.Data[i] = f
+	}
+	return msgpack.Marshal(batch)
+}
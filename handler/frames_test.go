@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func frameCount(name string) int64 {
+	if v, ok := frameMetrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// unmarshalMsgpack decodes data by JSON field names, as clients do.
+func unmarshalMsgpack(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+func TestBroadcastsAreEncodedOncePerCodec(t *testing.T) {
+	h, _, _ := newRelayedHubs(t)
+	var clients []*Client
+	for i := 1; i <= 4; i++ {
+		clients = append(clients, addTestClient(t, h, i))
+	}
+	clients[3].codec = msgpackCodec{}
+	encoded, reused := frameCount("encoded"), frameCount("reused")
+
+	h.broadcastLocal(WSMessage{Type: "announcement", Data: "hello"})
+	frames := make([][]byte, len(clients))
+	for i, c := range clients {
+		msgs := received(c, "announcement")
+		if len(msgs) != 1 {
+			t.Fatalf("client %d got %d announcements", i, len(msgs))
+		}
+		data, err := encodeFrame(c.codec, msgs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames[i] = data
+	}
+
+	if n := frameCount("encoded") - encoded; n != 2 {
+		t.Errorf("encoded %d times, want once per codec", n)
+	}
+	if n := frameCount("reused") - reused; n != 2 {
+		t.Errorf("reused %d times, want 2", n)
+	}
+	if &frames[0][0] != &frames[1][0] || &frames[0][0] != &frames[2][0] {
+		t.Error("JSON clients got separately encoded copies")
+	}
+	var msg WSMessage
+	if err := unmarshalMsgpack(frames[3], &msg); err != nil || msg.Data != "hello" {
+		t.Errorf("msgpack client got %+v, %v", msg, err)
+	}
+}
+
+func TestMessagesAreBatched(t *testing.T) {
+	msgs := []WSMessage{{Type: "chat", Data: "one"}, {Type: "chat", Data: "two"}}
+
+	single, err := encodeFrame(jsonCodec{}, msgs[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var alone WSMessage
+	if err := json.Unmarshal(single, &alone); err != nil || alone.Type != "chat" {
+		t.Errorf("a lone message was sent as %s", single)
+	}
+
+	type batch struct {
+		Type string      `json:"type"`
+		Data []WSMessage `json:"data"`
+	}
+	for name, c := range map[string]codec{"json": jsonCodec{}, "msgpack": msgpackCodec{}} {
+		data, err := encodeFrame(c, msgs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got batch
+		if name == "json" {
+			err = json.Unmarshal(data, &got)
+		} else {
+			err = unmarshalMsgpack(data, &got)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got.Type != "batch" || len(got.Data) != 2 || got.Data[0].Data != "one" || got.Data[1].Data != "two" {
+			t.Errorf("%s batch = %+v", name, got)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// heartbeat pings c every PingInterval until ctx is done. A ping that isn't
+// answered within PongTimeout, or nothing heard from c for ReadIdleTimeout,
+// closes the connection so the read loop ends and c is torn down. So does
+// c's session expiring or being revoked, checked every
+// sessionRecheckInterval, so clients that never send are logged out too.
+func (h *Hub) heartbeat(ctx context.Context, c *Client) {
+	ticker := h.clock.NewTicker(h.config.PingInterval)
+	defer ticker.Stop()
+	recheck := h.clock.NewTicker(sessionRecheckInterval)
+	defer recheck.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-recheck.C():
+			if !h.sessions.stillValid(c.session) {
+				log.Printf("🔒 Closing client of account %d, its session expired or was revoked", c.AccountID())
+				c.conn.Close(websocket.StatusPolicyViolation, "session expired")
+				return
+			}
+			continue
+		case <-ticker.C():
+		}
+
+		if idle := h.clock.Now().Sub(c.lastSeen()); idle >= h.config.ReadIdleTimeout {
+			log.Printf("💤 Closing idle client of account %d, nothing heard for %s", c.AccountID(), idle.Round(time.Second))
+			c.conn.Close(websocket.StatusPolicyViolation, "idle timeout")
+			return
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, h.config.PongTimeout)
+		err := c.conn.Ping(pingCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("💔 No pong from client of account %d: %v", c.AccountID(), err)
+			c.conn.CloseNow()
+			return
+		}
+		c.seen(h.clock.Now())
+	}
+}
+
+// teardown removes a disconnected client and tells the room what went with
+// it: its account's presence and, unless another client has it, its player,
+// whose last position is saved.
+func (h *Hub) teardown(c *Client) {
+	playerID := c.ControlledPlayer()
+	h.RemoveClient(c)
+	h.leavePresence(c)
+	if playerID == 0 {
+		return
+	}
+	h.flushPositions()
+	for _, p := range h.controlledPlayers() {
+		if p.ID == playerID {
+			return
+		}
+	}
+	h.Broadcast(WSMessage{Type: "player_offline", Data: map[string]interface{}{"id": playerID}})
+}
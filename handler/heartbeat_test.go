@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// startHeartbeat runs the heartbeat of a connected client of a hub on a fake
+// clock, returning once its tickers are set, and the channel with the error
+// that ended the client end's reads.
+func startHeartbeat(t *testing.T, config HubConfig) (*Hub, *Client, *FakeClock, <-chan error) {
+	t.Helper()
+	clock := NewFakeClock(time.Unix(1000, 0))
+	config.Clock = clock
+	store := NewMemoryStore()
+	h := NewHub(store, NewSessionManager(store.Sessions, []byte("secret"), time.Hour),
+		NewDirectMessenger(store.Messages), NewStatusStore(store.Accounts), NewMemoryPubSub(), config, mustLobby(t, store))
+	_, sess, err := h.sessions.Issue(1, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, closed := testConn(t)
+	c := &Client{conn: conn, session: sess}
+	c.seen(clock.Now())
+	// Pongs are only read while something reads, as serve does.
+	go func() {
+		for {
+			if _, _, err := conn.Read(t.Context()); err != nil {
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		h.heartbeat(ctx, c)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		clock.mu.Lock()
+		n := len(clock.tickers)
+		clock.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the heartbeat never started its tickers")
+		}
+	}
+	return h, c, clock, closed
+}
+
+func TestHeartbeatKeepsAnsweringClients(t *testing.T) {
+	config := DefaultHubConfig()
+	config.PingInterval, config.ReadIdleTimeout = 20*time.Second, 30*time.Second
+	_, c, clock, closed := startHeartbeat(t, config)
+
+	for i := 0; i < 3; i++ {
+		clock.Advance(config.PingInterval)
+		want := clock.Now()
+		for deadline := time.Now().Add(5 * time.Second); c.lastSeen().Before(want); time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("ping %d was never answered", i+1)
+			}
+		}
+	}
+	select {
+	case err := <-closed:
+		t.Fatalf("a client answering pings was closed: %v", err)
+	default:
+	}
+}
+
+func TestHeartbeatClosesIdleClients(t *testing.T) {
+	config := DefaultHubConfig()
+	config.PingInterval, config.ReadIdleTimeout = 20*time.Second, 20*time.Second
+	_, _, clock, closed := startHeartbeat(t, config)
+
+	clock.Advance(config.PingInterval)
+	select {
+	case err := <-closed:
+		if websocket.CloseStatus(err) != websocket.StatusPolicyViolation {
+			t.Errorf("closed with %v, want %d", err, websocket.StatusPolicyViolation)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("an idle client was left open")
+	}
+}
@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// Error codes carried in "error" reply frames.
+const (
+	ErrCodeBadJSON        = "bad_json"
+	ErrCodeInvalidPayload = "invalid_payload"
+	ErrCodeUnknownType    = "unknown_type"
+	ErrCodeRateLimited    = "rate_limited"
+	ErrCodeInternal       = "internal_error"
+)
+
+const (
+	maxNameLength = 32
+	maxHealth     = 100
+)
+
+// MessageError is sent back to the client as the data of an "error" frame
+// when one of its messages is rejected.
+type MessageError struct {
+	Code    string `json:"code"`
+	Type    string `json:"type,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *MessageError) Error() string {
+	if e.Type == "" {
+		return e.Code + ": " + e.Message
+	}
+	return e.Code + " (" + e.Type + "): " + e.Message
+}
+
+// Payload is implemented by every typed client message.
+type Payload interface {
+	Validate() error
+}
+
+// inboundMessage is the raw envelope read from the socket; Data is still in
+// the connection's encoding and is decoded later into the payload registered
+// for Type.
+type inboundMessage struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type MoveMessage struct {
+	ID int `json:"id"`
+	X  int `json:"x"`
+	Y  int `json:"y"`
+}
+
+// Validate only checks the player ID; positions are clamped against the
+// world by the move handler.
+func (m *MoveMessage) Validate() error {
+	return validateID("id", m.ID)
+}
+
+type CreateMessage struct {
+	Name string `json:"name"`
+}
+
+func (m *CreateMessage) Validate() error {
+	return validateName(m.Name)
+}
+
+type ChangeNameMessage struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func (m *ChangeNameMessage) Validate() error {
+	if err := validateID("id", m.ID); err != nil {
+		return err
+	}
+	return validateName(m.Name)
+}
+
+type GetPlayersMessage struct{}
+
+func (m *GetPlayersMessage) Validate() error { return nil }
+
+type ControlPlayerMessage struct {
+	PlayerID int `json:"playerId"`
+}
+
+func (m *ControlPlayerMessage) Validate() error {
+	return validateID("playerId", m.PlayerID)
+}
+
+type SaveDrawingMessage struct {
+	PlayerID int    `json:"playerId"`
+	Image    string `json:"image"`
+}
+
+func (m *SaveDrawingMessage) Validate() error {
+	if err := validateID("playerId", m.PlayerID); err != nil {
+		return err
+	}
+	if m.Image == "" {
+		return errors.New("image is required")
+	}
+	return nil
+}
+
+type DeletePlayerMessage struct {
+	ID int `json:"id"`
+}
+
+func (m *DeletePlayerMessage) Validate() error {
+	return validateID("id", m.ID)
+}
+
+// SpawnMessage is shared by spawn_bullet and spawn_medkit: the client's
+// intent to fire from its player towards a point.
+type SpawnMessage struct {
+	FromID  int     `json:"fromId"`
+	TargetX float64 `json:"targetX"`
+	TargetY float64 `json:"targetY"`
+}
+
+func (m *SpawnMessage) Validate() error {
+	if err := validateID("fromId", m.FromID); err != nil {
+		return err
+	}
+	if math.IsNaN(m.TargetX) || math.IsInf(m.TargetX, 0) || math.IsNaN(m.TargetY) || math.IsInf(m.TargetY, 0) {
+		return errors.New("target must be a finite point")
+	}
+	return nil
+}
+
+// decodeEnvelope parses a raw frame far enough to route it by type.
+func decodeEnvelope(raw []byte) (inboundMessage, *MessageError) {
+	var env inboundMessage
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return env, &MessageError{Code: ErrCodeBadJSON, Message: err.Error()}
+	}
+	if env.Type == "" {
+		return env, &MessageError{Code: ErrCodeInvalidPayload, Message: "type is required"}
+	}
+	return env, nil
+}
+
+// decodePayload strictly decodes data into a fresh payload and validates it.
+func decodePayload(data json.RawMessage, newPayload func() Payload) (Payload, error) {
+	payload := newPayload()
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		data = []byte("{}")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(payload); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after payload")
+	}
+	if err := payload.Validate(); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func validateID(field string, id int) error {
+	if id <= 0 {
+		return fmt.Errorf("%s must be a positive integer", field)
+	}
+	return nil
+}
+
+func validateName(name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("name is required")
+	}
+	if utf8.RuneCountInString(name) > maxNameLength {
+		return fmt.Errorf("name must be at most %d characters", maxNameLength)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"expvar"
+	"log"
+	"sync"
+	"time"
+)
+
+// messageMetrics is published at /debug/vars.
+var messageMetrics = expvar.NewMap("ws_messages")
+
+// LoggingMiddleware logs every handled message with its duration and outcome.
+func LoggingMiddleware(next MessageHandler) MessageHandler {
+	return func(req *Request) error {
+		start := time.Now()
+		err := next(req)
+		if err != nil {
+			log.Printf("📨 %s failed after %v: %v", req.Type, time.Since(start), err)
+		} else {
+			log.Printf("📨 %s handled in %v", req.Type, time.Since(start))
+		}
+		return err
+	}
+}
+
+// MetricsMiddleware counts handled messages, failures and total handler time
+// per message type.
+func MetricsMiddleware(next MessageHandler) MessageHandler {
+	return func(req *Request) error {
+		start := time.Now()
+		err := next(req)
+		messageMetrics.Add(req.Type+".count", 1)
+		messageMetrics.Add(req.Type+".duration_us", time.Since(start).Microseconds())
+		if err != nil {
+			messageMetrics.Add(req.Type+".errors", 1)
+		}
+		return err
+	}
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimitMiddleware allows each client perSecond messages on average with
+// bursts of up to burst messages, rejecting the rest with rate_limited.
+func RateLimitMiddleware(perSecond float64, burst int) Middleware {
+	var lock sync.Mutex
+	buckets := make(map[*Client]*tokenBucket)
+	lastSweep := time.Now()
+	// A bucket left alone this long has refilled, so forgetting it is harmless.
+	idle := time.Duration(float64(burst)/perSecond*float64(time.Second)) + time.Second
+
+	allow := func(c *Client) bool {
+		lock.Lock()
+		defer lock.Unlock()
+
+		now := time.Now()
+		if now.Sub(lastSweep) > idle {
+			for client, b := range buckets {
+				if now.Sub(b.last) > idle {
+					delete(buckets, client)
+				}
+			}
+			lastSweep = now
+		}
+
+		b, ok := buckets[c]
+		if !ok {
+			b = &tokenBucket{tokens: float64(burst), last: now}
+			buckets[c] = b
+		}
+		b.tokens += now.Sub(b.last).Seconds() * perSecond
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+		b.last = now
+		if b.tokens < 1 {
+			return false
+		}
+		b.tokens--
+		return true
+	}
+
+	return func(next MessageHandler) MessageHandler {
+		return func(req *Request) error {
+			if !allow(req.Client) {
+				return &MessageError{Code: ErrCodeRateLimited, Message: "too many messages, slow down"}
+			}
+			return next(req)
+		}
+	}
+}
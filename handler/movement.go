@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	CorrectionOutOfBounds = "out_of_bounds"
+	CorrectionTooFast     = "too_fast"
+
+	// moveGrace is extra travel time a player may bank, so that moves
+	// bunched up by network jitter aren't mistaken for speeding.
+	moveGrace = 250 * time.Millisecond
+
+	// maxMoveInterval caps the time a player can bank by standing still, so
+	// one idle for a minute can't teleport across the map in one step.
+	maxMoveInterval = time.Second
+)
+
+// Correction tells a client where its player really is after the server
+// refused or trimmed one of its moves.
+type Correction struct {
+	ID     int    `json:"id"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Reason string `json:"reason"`
+}
+
+// movementRules decides how far a move may go. Each player has a travel
+// allowance that refills at maxSpeed, up to burst, and every move spends
+// what it travels, so sending moves more often doesn't make a player faster.
+type movementRules struct {
+	maxX, maxY int
+	maxSpeed   float64
+}
+
+func newMovementRules(config HubConfig) movementRules {
+	return movementRules{
+		maxX:     config.WorldWidth - playerSize,
+		maxY:     config.WorldHeight - playerSize,
+		maxSpeed: config.MaxSpeed,
+	}
+}
+
+// burst is the most a player's allowance holds: what it may cover in one
+// move after standing still.
+func (r movementRules) burst() float64 {
+	return r.maxSpeed * (maxMoveInterval + moveGrace).Seconds()
+}
+
+// refill returns the allowance of a player that had left over and last moved
+// elapsed ago.
+func (r movementRules) refill(left float64, elapsed time.Duration) float64 {
+	return math.Min(left+r.maxSpeed*elapsed.Seconds(), r.burst())
+}
+
+// limit returns where a player at (fromX, fromY) with allowance left actually
+// ends up when asking for (x, y), how far that is, and why it differs from
+// (x, y), if it does.
+func (r movementRules) limit(fromX, fromY, x, y int, allowance float64) (int, int, float64, string) {
+	reason := ""
+	if cx, cy := clampInt(x, 0, r.maxX), clampInt(y, 0, r.maxY); cx != x || cy != y {
+		x, y, reason = cx, cy, CorrectionOutOfBounds
+	}
+
+	dx, dy := float64(x-fromX), float64(y-fromY)
+	dist := math.Hypot(dx, dy)
+	if dist > allowance {
+		// Move as far as allowed towards the requested point.
+		scale := allowance / dist
+		x = fromX + int(dx*scale)
+		y = fromY + int(dy*scale)
+		dist = math.Hypot(float64(x-fromX), float64(y-fromY))
+		reason = CorrectionTooFast
+	}
+	return x, y, dist, reason
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func newMovementTest(x, y int) (*world, movementRules, time.Time) {
+	w := newWorld(500)
+	w.add(Player{ID: 1, X: x, Y: y})
+	return w, newMovementRules(DefaultHubConfig()), time.Unix(1000, 0)
+}
+
+func TestMovesInQuickSuccessionShareOneAllowance(t *testing.T) {
+	w, rules, now := newMovementTest(256, 256)
+
+	// 20 moves 15ms apart, each asking for 300px more.
+	x := 256
+	var trimmed int
+	for i := 0; i < 20; i++ {
+		pos, reason, _ := w.move(1, x+300, 256, now, rules)
+		if reason == CorrectionTooFast {
+			trimmed++
+		}
+		x = pos.X
+		now = now.Add(15 * time.Millisecond)
+	}
+
+	// A full allowance plus what refills over the 285ms between the first
+	// and the last move.
+	limit := 256 + rules.burst() + rules.maxSpeed*0.285
+	if float64(x) > limit+1 {
+		t.Errorf("travelled to x=%d within 300ms, want at most %.0f", x, limit)
+	}
+	if trimmed < 15 {
+		t.Errorf("only %d of 20 moves were trimmed", trimmed)
+	}
+}
+
+func TestMovesAtMaxSpeedAreNotTrimmed(t *testing.T) {
+	w, rules, now := newMovementTest(100, 100)
+	step := 50 * time.Millisecond
+	stride := int(rules.maxSpeed * step.Seconds())
+
+	x := 100
+	for i := 0; i < 100; i++ {
+		now = now.Add(step)
+		pos, reason, _ := w.move(1, x+stride, 100, now, rules)
+		if reason != "" || pos.X != x+stride {
+			t.Fatalf("move %d to x=%d corrected to %d (%s)", i, x+stride, pos.X, reason)
+		}
+		x = pos.X
+	}
+}
+
+func TestStandingStillBanksAtMostOneBurst(t *testing.T) {
+	w, rules, now := newMovementTest(100, 100)
+	w.move(1, 100, 100, now, rules)
+
+	pos, reason, _ := w.move(1, 5100, 100, now.Add(time.Minute), rules)
+	if reason != CorrectionTooFast {
+		t.Errorf("reason = %q, want %q", reason, CorrectionTooFast)
+	}
+	if got, want := pos.X-100, int(rules.burst()); got != want {
+		t.Errorf("moved %dpx after a minute idle, want %d", got, want)
+	}
+}
+
+func TestMovesOutOfBoundsAreClamped(t *testing.T) {
+	w, rules, now := newMovementTest(10, 10)
+	pos, reason, _ := w.move(1, -50, 10, now, rules)
+	if reason != CorrectionOutOfBounds || pos.X != 0 {
+		t.Errorf("moved to %d (%s), want 0 (%s)", pos.X, reason, CorrectionOutOfBounds)
+	}
+}
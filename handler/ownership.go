@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+)
+
+const ErrCodeForbidden = "forbidden"
+
+var errPlayerNotFound = &MessageError{Code: ErrCodeForbidden, Message: "player does not exist"}
+
+// playerOwner returns the account that owns playerID, or 0 if the player has
+// no owner yet. Owners only change through the hub, so answers are cached.
+func (h *Hub) playerOwner(playerID int) (int, error) {
+	h.ownersLock.Lock()
+	owner, ok := h.owners[playerID]
+	h.ownersLock.Unlock()
+	if ok {
+		return owner, nil
+	}
+
+	owner, err := h.store.Players.Owner(playerID)
+	if errors.Is(err, ErrPlayerNotFound) {
+		return 0, errPlayerNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("looking up owner of player %d: %w", playerID, err)
+	}
+
+	h.setPlayerOwner(playerID, owner)
+	return owner, nil
+}
+
+func (h *Hub) setPlayerOwner(playerID, accountID int) {
+	h.ownersLock.Lock()
+	h.owners[playerID] = accountID
+	h.ownersLock.Unlock()
+}
+
+// forgetPlayer drops a deleted player from the owner cache and releases it
+// from every client controlling it.
+func (h *Hub) forgetPlayer(playerID int) {
+	h.ownersLock.Lock()
+	delete(h.owners, playerID)
+	h.ownersLock.Unlock()
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for client := range h.clients {
+		client.releasePlayer(playerID)
+	}
+}
+
+// claimPlayer makes accountID the owner of an unowned player. It reports
+// whether accountID owns the player afterwards.
+func (h *Hub) claimPlayer(playerID, accountID int) (bool, error) {
+	owner, err := h.playerOwner(playerID)
+	if err != nil {
+		return false, err
+	}
+	if owner == accountID {
+		return true, nil
+	}
+	if owner != 0 {
+		return false, nil
+	}
+
+	claimed, err := h.store.Players.Claim(playerID, accountID)
+	if err != nil {
+		return false, fmt.Errorf("claiming player %d for account %d: %w", playerID, accountID, err)
+	}
+	if !claimed {
+		// Someone else got there first; reload the real owner.
+		h.ownersLock.Lock()
+		delete(h.owners, playerID)
+		h.ownersLock.Unlock()
+		owner, err = h.playerOwner(playerID)
+		return owner == accountID, err
+	}
+	h.setPlayerOwner(playerID, accountID)
+	return true, nil
+}
+
+// requireOwner rejects messages about players the client's account doesn't own.
+func (h *Hub) requireOwner(playerID func(Payload) int) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(req *Request) error {
+			id := playerID(req.Payload)
+			if _, ok := h.world.get(id); !ok {
+				return &MessageError{Code: ErrCodeForbidden, Message: fmt.Sprintf("player %d is not in this room", id)}
+			}
+			owner, err := h.playerOwner(id)
+			if err != nil {
+				return err
+			}
+			if owner != req.Client.AccountID() {
+				return &MessageError{Code: ErrCodeForbidden, Message: fmt.Sprintf("player %d is not yours", id)}
+			}
+			return next(req)
+		}
+	}
+}
+
+// requireControl rejects messages about any player other than the one the
+// client currently controls.
+func requireControl(playerID func(Payload) int) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(req *Request) error {
+			id := playerID(req.Payload)
+			if req.Client.ControlledPlayer() != id {
+				return &MessageError{Code: ErrCodeForbidden, Message: fmt.Sprintf("you are not controlling player %d", id)}
+			}
+			return next(req)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"strconv"
+	"testing"
+)
+
+// addPlayer creates a player owned by accountID in h's room and world.
+func addPlayer(t *testing.T, h *Hub, name string, accountID int) int {
+	t.Helper()
+	id := mustPlayer(t, h.store, name, accountID, h.room.ID)
+	p, err := h.store.Players.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.world.add(p)
+	return id
+}
+
+func TestOtherAccountsCannotUsePlayers(t *testing.T) {
+	h, _, _ := newRelayedHubs(t)
+	alice, bob := mustAccount(t, h.store, "alice"), mustAccount(t, h.store, "bob")
+	alicePlayer := addPlayer(t, h, "alice", alice)
+	bobPlayer := addPlayer(t, h, "bob", bob)
+	owner := addTestClient(t, h, alice)
+	owner.controlPlayer(alicePlayer)
+	other := addTestClient(t, h, bob)
+	other.controlPlayer(bobPlayer)
+
+	id := strconv.Itoa(alicePlayer)
+	messages := []struct{ name, msg string }{
+		{"move", `{"type": "move", "data": {"id": ` + id + `, "x": 50, "y": 50}}`},
+		{"save_drawing", `{"type": "save_drawing", "data": {"playerId": ` + id + `, "image": "data:image/png;base64,AAAA"}}`},
+		{"delete_player", `{"type": "delete_player", "data": {"id": ` + id + `}}`},
+	}
+	for _, m := range messages {
+		if err := dispatchJSON(t, h, other, m.msg); err == nil || err.Code != ErrCodeForbidden {
+			t.Errorf("%s by another account: got %v, want %s", m.name, err, ErrCodeForbidden)
+		}
+	}
+	if p, ok := h.world.get(alicePlayer); !ok || p.X != 10 {
+		t.Errorf("alice's player is now %+v, %v", p, ok)
+	}
+
+	// The owner can still do all of it.
+	for _, m := range messages {
+		if err := dispatchJSON(t, h, owner, m.msg); err != nil {
+			t.Errorf("%s by the owner: %v", m.name, err)
+		}
+	}
+}
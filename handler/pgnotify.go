@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxNotifyPayload is just under Postgres' limit for a NOTIFY payload.
+const maxNotifyPayload = 7999
+
+// PostgresPubSub carries events between instances with LISTEN/NOTIFY on the
+// database they already share. Events published while an instance's listener
+// is reconnecting are lost to it.
+type PostgresPubSub struct {
+	db       *sql.DB
+	listener *pq.Listener
+
+	mu     sync.Mutex
+	next   int
+	topics map[string]map[int]func([]byte)
+}
+
+// NewPostgresPubSub listens on a dedicated connection to dsn and publishes
+// through db.
+func NewPostgresPubSub(db *sql.DB, dsn string) *PostgresPubSub {
+	ps := &PostgresPubSub{db: db, topics: make(map[string]map[int]func([]byte))}
+	ps.listener = pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventDisconnected:
+			log.Printf("⚠️ Lost the pub/sub connection: %v", err)
+		case pq.ListenerEventReconnected:
+			log.Println("🔌 Pub/sub connection restored; events sent meanwhile were missed")
+		case pq.ListenerEventConnectionAttemptFailed:
+			log.Printf("❌ Pub/sub reconnect failed: %v", err)
+		}
+	})
+	go ps.run()
+	return ps
+}
+
+func (ps *PostgresPubSub) Publish(channel string, payload []byte) error {
+	if len(payload) > maxNotifyPayload {
+		return fmt.Errorf("event of %d bytes is over the %d byte NOTIFY limit", len(payload), maxNotifyPayload)
+	}
+	_, err := ps.db.Exec("SELECT pg_notify($1, $2)", channel, string(payload))
+	return err
+}
+
+func (ps *PostgresPubSub) Subscribe(channel string, fn func([]byte)) (func(), error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.topics[channel] == nil {
+		if err := ps.listener.Listen(channel); err != nil {
+			return nil, err
+		}
+		ps.topics[channel] = make(map[int]func([]byte))
+	}
+	ps.next++
+	id := ps.next
+	ps.topics[channel][id] = fn
+	return func() {
+		ps.mu.Lock()
+		defer ps.mu.Unlock()
+		delete(ps.topics[channel], id)
+		if len(ps.topics[channel]) == 0 {
+			delete(ps.topics, channel)
+			if err := ps.listener.Unlisten(channel); err != nil {
+				log.Printf("❌ Unlisten %s: %v", channel, err)
+			}
+		}
+	}, nil
+}
+
+// Close stops listening.
+func (ps *PostgresPubSub) Close() error {
+	return ps.listener.Close()
+}
+
+// run hands notifications to subscribers until the listener is closed.
+func (ps *PostgresPubSub) run() {
+	for n := range ps.listener.NotificationChannel() {
+		if n == nil {
+			continue // reconnected
+		}
+		ps.mu.Lock()
+		subs := make([]func([]byte), 0, len(ps.topics[n.Channel]))
+		for _, fn := range ps.topics[n.Channel] {
+			subs = append(subs, fn)
+		}
+		ps.mu.Unlock()
+		for _, fn := range subs {
+			fn([]byte(n.Extra))
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+)
+
+var playerColors = []string{
+	"teal", "tomato", "orange", "green", "gold", "pink",
+	"cyan", "magenta", "lime", "coral", "brown", "orchid",
+	"lightblue", "lightgreen", "khaki", "peachpuff", "lavender"}
+
+func (h *Hub) registerPlayerHandlers() {
+	h.Handle("move", func() Payload { return &MoveMessage{} }, h.handleMove,
+		requireControl(func(p Payload) int { return p.(*MoveMessage).ID }))
+	h.Handle("create", func() Payload { return &CreateMessage{} }, h.handleCreate)
+	h.Handle("change_name", func() Payload { return &ChangeNameMessage{} }, h.handleChangeName,
+		h.requireOwner(func(p Payload) int { return p.(*ChangeNameMessage).ID }))
+	h.Handle("get_players", func() Payload { return &GetPlayersMessage{} }, h.handleGetPlayers)
+	h.Handle("control_player", func() Payload { return &ControlPlayerMessage{} }, h.handleControlPlayer)
+	h.Handle("delete_player", func() Payload { return &DeletePlayerMessage{} }, h.handleDeletePlayer,
+		h.requireOwner(func(p Payload) int { return p.(*DeletePlayerMessage).ID }))
+}
+
+// resumeLastPlayer gives a newly connected client control of its account's
+// last player, which the page auto-controls on load.
+func (h *Hub) resumeLastPlayer(c *Client) {
+	lastPlayerID, err := h.store.Accounts.LastPlayer(c.AccountID())
+	if err != nil {
+		log.Printf("❌ Error loading last player for account %d: %v", c.AccountID(), err)
+		return
+	}
+	if lastPlayerID == nil {
+		return
+	}
+
+	playerID := *lastPlayerID
+	if _, ok := h.world.get(playerID); !ok {
+		// The last player lives in another room.
+		return
+	}
+	owned, err := h.claimPlayer(playerID, c.AccountID())
+	if err != nil {
+		log.Printf("❌ Error checking owner of last player %d: %v", playerID, err)
+		return
+	}
+	if owned {
+		c.controlPlayer(playerID)
+	}
+}
+
+// handleMove records the new position, trimmed to the world bounds and the
+// maximum speed; clients hear about it in the next tick delta and the
+// database in the next position flush. The sender is told where its player
+// really is if the move was trimmed.
+func (h *Hub) handleMove(req *Request) error {
+	m := req.Payload.(*MoveMessage)
+	pos, reason, ok := h.world.move(m.ID, m.X, m.Y, h.clock.Now(), h.movement)
+	if !ok {
+		return errPlayerNotFound
+	}
+	h.touchPresence(req.Client.AccountID())
+	if reason != "" {
+		req.Client.Send(WSMessage{Type: "correction", Data: Correction{ID: pos.ID, X: pos.X, Y: pos.Y, Reason: reason}})
+	}
+	h.positions.record(pos)
+	return nil
+}
+
+func (h *Hub) handleCreate(req *Request) error {
+	m := req.Payload.(*CreateMessage)
+	name := m.Name
+	accountID := req.Client.AccountID()
+
+	log.Printf("👤 Creating player: name='%s', accountId=%d", name, accountID)
+
+	color := playerColors[rand.Intn(len(playerColors))]
+	x := rand.Intn(800)
+	y := rand.Intn(600)
+
+	id, err := h.store.Players.Create(Player{Name: name, X: x, Y: y, Color: color, Health: maxHealth}, accountID, h.room.ID)
+	if err != nil {
+		return fmt.Errorf("creating player: %w", err)
+	}
+	h.setPlayerOwner(id, accountID)
+	req.Client.controlPlayer(id)
+
+	log.Printf("✅ Player created successfully: id=%d, name='%s', position=(%d,%d)", id, name, x, y)
+
+	// Update account's last_player_id
+	if err := h.store.Accounts.SetLastPlayer(accountID, &id); err != nil {
+		log.Printf("❌ Error updating account last_player_id: %v", err)
+	} else {
+		log.Printf("✅ Updated account %d last_player_id to %d", accountID, id)
+	}
+
+	player := Player{ID: id, Name: name, X: x, Y: y, Color: color, Health: maxHealth}
+	h.world.add(player)
+	h.publishPlayer(id)
+	req.Client.Send(WSMessage{Type: "created", Data: player})
+
+	log.Printf("📤 Sent player creation messages for player %d", id)
+	return nil
+}
+
+func (h *Hub) handleChangeName(req *Request) error {
+	m := req.Payload.(*ChangeNameMessage)
+	if err := h.store.Players.Rename(m.ID, m.Name); err != nil {
+		return fmt.Errorf("renaming player %d: %w", m.ID, err)
+	}
+	h.world.update(m.ID, func(p *Player) { p.Name = m.Name })
+	h.publishPlayer(m.ID)
+	h.Broadcast(WSMessage{Type: "name_changed", Data: map[string]interface{}{"id": m.ID, "name": m.Name}})
+	return nil
+}
+
+func (h *Hub) handleGetPlayers(req *Request) error {
+	chars := h.world.all()
+
+	log.Printf("📋 Found %d players in the world: %v", len(chars), func() []int {
+		ids := make([]int, len(chars))
+		for i, p := range chars {
+			ids[i] = p.ID
+		}
+		return ids
+	}())
+
+	req.Client.Send(WSMessage{Type: "players", Data: chars})
+	return nil
+}
+
+func (h *Hub) handleControlPlayer(req *Request) error {
+	m := req.Payload.(*ControlPlayerMessage)
+	playerID := m.PlayerID
+	accountID := req.Client.AccountID()
+
+	log.Printf("🎮 control_player: account %d trying to control player %d", accountID, playerID)
+
+	// Only players in this room can be claimed or controlled from it.
+	if _, ok := h.world.get(playerID); !ok {
+		_, err := h.store.Players.Owner(playerID)
+		if err == nil {
+			return &MessageError{Code: ErrCodeForbidden, Message: fmt.Sprintf("player %d is in another room", playerID)}
+		}
+		if !errors.Is(err, ErrPlayerNotFound) {
+			return fmt.Errorf("looking up player %d: %w", playerID, err)
+		}
+		log.Printf("❌ Cannot set last_player_id to %d - player does not exist in database", playerID)
+		// Set last_player_id to NULL instead of non-existent player
+		if err := h.store.Accounts.SetLastPlayer(accountID, nil); err != nil {
+			return fmt.Errorf("setting last_player_id to NULL for account %d: %w", accountID, err)
+		}
+		log.Printf("✅ Set last_player_id to NULL for account %d", accountID)
+		return errPlayerNotFound
+	}
+
+	// Players created before accounts owned them are claimed by whoever
+	// controls them first.
+	owned, err := h.claimPlayer(playerID, accountID)
+	if err != nil {
+		return err
+	}
+	if !owned {
+		log.Printf("🚫 Account %d tried to control player %d owned by another account", accountID, playerID)
+		return &MessageError{Code: ErrCodeForbidden, Message: fmt.Sprintf("player %d is not yours", playerID)}
+	}
+	req.Client.controlPlayer(playerID)
+
+	// Update account's last controlled player
+	if err := h.store.Accounts.SetLastPlayer(accountID, &playerID); err != nil {
+		return fmt.Errorf("updating last player: account=%d, player=%d: %w", accountID, playerID, err)
+	}
+	log.Printf("✅ Successfully updated account %d last_player_id to %d", accountID, playerID)
+	return nil
+}
+
+func (h *Hub) handleDeletePlayer(req *Request) error {
+	id := req.Payload.(*DeletePlayerMessage).ID
+	log.Printf("🗑️ Account %d deleting player %d", req.Client.AccountID(), id)
+	return h.deletePlayer(id, map[string]interface{}{"id": id, "reason": "deleted"})
+}
+
+// deletePlayer removes a player with its drawings and broadcasts event as
+// player_deleted.
+func (h *Hub) deletePlayer(id int, event map[string]interface{}) error {
+	if err := h.store.Players.Delete(id); err != nil {
+		return fmt.Errorf("deleting player %d: %w", id, err)
+	}
+
+	log.Printf("✅ Successfully deleted player %d from database", id)
+	h.forgetPlayer(id)
+	h.world.remove(id)
+	h.positions.forget(id)
+	h.publish(roomEvent{Removed: id})
+
+	// Broadcast player deletion to all clients
+	h.Broadcast(WSMessage{Type: "player_deleted", Data: event})
+	return nil
+}
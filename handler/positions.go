@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// positionMetrics is published at /debug/vars.
+var positionMetrics = expvar.NewMap("position_flush")
+
+// positionWriter keeps the latest position of every moved player and writes
+// them to the database in one batch per flush, instead of one UPDATE per
+// move. Only the newest position of a player is kept, and flushes run one at
+// a time, so an older position never overwrites a newer one.
+type positionWriter struct {
+	players PlayerStore
+
+	mu      sync.Mutex
+	pending map[int]PlayerPosition
+
+	flushing sync.Mutex
+}
+
+func newPositionWriter(players PlayerStore) *positionWriter {
+	return &positionWriter{players: players, pending: make(map[int]PlayerPosition)}
+}
+
+// record queues pos for the next flush, replacing any earlier position of
+// the same player.
+func (w *positionWriter) record(pos PlayerPosition) {
+	w.mu.Lock()
+	w.pending[pos.ID] = pos
+	w.mu.Unlock()
+}
+
+// forget drops a deleted player's pending position.
+func (w *positionWriter) forget(id int) {
+	w.mu.Lock()
+	delete(w.pending, id)
+	w.mu.Unlock()
+}
+
+// flush writes every pending position. If the write fails they are kept for
+// the next flush, unless the player has moved again meanwhile.
+func (w *positionWriter) flush() error {
+	w.flushing.Lock()
+	defer w.flushing.Unlock()
+
+	w.mu.Lock()
+	batch := w.pending
+	w.pending = make(map[int]PlayerPosition)
+	w.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	positions := make([]PlayerPosition, 0, len(batch))
+	for _, pos := range batch {
+		positions = append(positions, pos)
+	}
+
+	start := time.Now()
+	err := w.players.SavePositions(positions)
+	elapsed := time.Since(start)
+
+	positionMetrics.Add("flushes", 1)
+	positionMetrics.Add("duration_us", elapsed.Microseconds())
+	last := new(expvar.Int)
+	last.Set(elapsed.Microseconds())
+	positionMetrics.Set("last_duration_us", last)
+	if err != nil {
+		positionMetrics.Add("errors", 1)
+		w.mu.Lock()
+		for id, pos := range batch {
+			if _, moved := w.pending[id]; !moved {
+				w.pending[id] = pos
+			}
+		}
+		w.mu.Unlock()
+		return fmt.Errorf("saving %d player positions: %w", len(batch), err)
+	}
+	positionMetrics.Add("positions", int64(len(batch)))
+	return nil
+}
+
+// flushPositions saves pending positions, logging failures; they are retried
+// on the next flush.
+func (h *Hub) flushPositions() {
+	if err := h.positions.flush(); err != nil {
+		log.Printf("❌ %v", err)
+	}
+}
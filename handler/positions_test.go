@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"errors"
+	"sort"
+	"testing"
+)
+
+// savingPlayers records every batch of positions saved through it, and can
+// fail a save after running during.
+type savingPlayers struct {
+	PlayerStore
+	saves  [][]PlayerPosition
+	fail   bool
+	during func()
+}
+
+func (s *savingPlayers) SavePositions(positions []PlayerPosition) error {
+	sort.Slice(positions, func(i, j int) bool { return positions[i].ID < positions[j].ID })
+	s.saves = append(s.saves, positions)
+	if s.during != nil {
+		s.during()
+	}
+	if s.fail {
+		return errors.New("database is down")
+	}
+	return s.PlayerStore.SavePositions(positions)
+}
+
+func newSavingPlayers(t *testing.T, names ...string) (*savingPlayers, []int) {
+	store := NewMemoryStore()
+	lobby := mustLobby(t, store)
+	var ids []int
+	for _, name := range names {
+		ids = append(ids, mustPlayer(t, store, name, 0, lobby.ID))
+	}
+	return &savingPlayers{PlayerStore: store.Players}, ids
+}
+
+func TestPositionFlushKeepsTheLatestPosition(t *testing.T) {
+	players, ids := newSavingPlayers(t, "a", "b")
+	w := newPositionWriter(players)
+	w.record(PlayerPosition{ID: ids[0], X: 1, Y: 1})
+	w.record(PlayerPosition{ID: ids[1], X: 5, Y: 5})
+	w.record(PlayerPosition{ID: ids[0], X: 2, Y: 2})
+
+	if err := w.flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := []PlayerPosition{{ID: ids[0], X: 2, Y: 2}, {ID: ids[1], X: 5, Y: 5}}
+	if len(players.saves) != 1 || len(players.saves[0]) != 2 || players.saves[0][0] != want[0] || players.saves[0][1] != want[1] {
+		t.Fatalf("saves = %v, want one batch of %v", players.saves, want)
+	}
+	if p, _ := players.Get(ids[0]); p.X != 2 {
+		t.Errorf("player saved at x=%d, want 2", p.X)
+	}
+
+	if err := w.flush(); err != nil || len(players.saves) != 1 {
+		t.Errorf("flushing nothing: %v, %d saves", err, len(players.saves))
+	}
+}
+
+func TestFailedPositionFlushIsRetriedWithoutOverwritingNewerMoves(t *testing.T) {
+	players, ids := newSavingPlayers(t, "a", "b")
+	w := newPositionWriter(players)
+	w.record(PlayerPosition{ID: ids[0], X: 1, Y: 1})
+	w.record(PlayerPosition{ID: ids[1], X: 5, Y: 5})
+
+	// Player a moves again while the failing write is in flight.
+	players.fail = true
+	players.during = func() { w.record(PlayerPosition{ID: ids[0], X: 3, Y: 3}) }
+	if err := w.flush(); err == nil {
+		t.Fatal("a failed save was reported as flushed")
+	}
+
+	players.fail, players.during = false, nil
+	if err := w.flush(); err != nil {
+		t.Fatal(err)
+	}
+	retry := players.saves[len(players.saves)-1]
+	want := []PlayerPosition{{ID: ids[0], X: 3, Y: 3}, {ID: ids[1], X: 5, Y: 5}}
+	if len(retry) != 2 || retry[0] != want[0] || retry[1] != want[1] {
+		t.Errorf("retried %v, want %v", retry, want)
+	}
+}
+
+func TestForgottenPlayersAreNotSaved(t *testing.T) {
+	players, ids := newSavingPlayers(t, "a")
+	w := newPositionWriter(players)
+	w.record(PlayerPosition{ID: ids[0], X: 1, Y: 1})
+	w.forget(ids[0])
+	if err := w.flush(); err != nil || len(players.saves) != 0 {
+		t.Errorf("flushing a forgotten player: %v, saves %v", err, players.saves)
+	}
+}
@@ -0,0 +1,429 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusBusy    = "busy"
+	StatusOffline = "offline"
+
+	maxStatusText = 80
+)
+
+// Presence is an account's availability in a room.
+type Presence struct {
+	AccountID int       `json:"accountId"`
+	Username  string    `json:"username"`
+	Status    string    `json:"status"`
+	Text      string    `json:"text"`
+	Since     time.Time `json:"since"`
+}
+
+// SetStatusMessage changes the sender's account status in every room.
+// Offline can't be chosen; it only means no client is connected.
+type SetStatusMessage struct {
+	Status string `json:"status"`
+	Text   string `json:"text"`
+}
+
+func (m *SetStatusMessage) Validate() error {
+	switch m.Status {
+	case StatusOnline, StatusAway, StatusBusy:
+	default:
+		return fmt.Errorf("status must be %q, %q or %q", StatusOnline, StatusAway, StatusBusy)
+	}
+	m.Text = strings.TrimSpace(m.Text)
+	if utf8.RuneCountInString(m.Text) > maxStatusText {
+		return fmt.Errorf("text must be at most %d characters", maxStatusText)
+	}
+	return nil
+}
+
+// StatusStore keeps the status each account chose, shared by every room's
+// hub so a change shows up wherever the account is connected. Once relayed,
+// changes also reach the hubs of other instances.
+type StatusStore struct {
+	accounts AccountStore
+
+	mu        sync.Mutex
+	listeners map[int]func(accountID int, status, text string)
+	nextID    int
+	bus       PubSub
+	instance  string
+}
+
+// statusChannel carries status changes between instances.
+const statusChannel = "ourgatther_statuses"
+
+// statusEvent is a status change published for other instances' hubs.
+type statusEvent struct {
+	Instance  string `json:"instance"`
+	AccountID int    `json:"accountId"`
+	Status    string `json:"status"`
+	Text      string `json:"text"`
+}
+
+func NewStatusStore(accounts AccountStore) *StatusStore {
+	return &StatusStore{accounts: accounts, listeners: make(map[int]func(int, string, string))}
+}
+
+// Relay publishes status changes to the other instances on bus and passes
+// theirs to this instance's hubs, until unsubscribe is called.
+func (s *StatusStore) Relay(bus PubSub, instanceID string) (unsubscribe func(), err error) {
+	s.mu.Lock()
+	s.bus, s.instance = bus, instanceID
+	s.mu.Unlock()
+	unsubscribe, err = bus.Subscribe(statusChannel, s.receive)
+	if err != nil {
+		return nil, fmt.Errorf("subscribing to status changes: %w", err)
+	}
+	return unsubscribe, nil
+}
+
+// Get returns accountID's username and chosen status.
+func (s *StatusStore) Get(accountID int) (username, status, text string, err error) {
+	return s.accounts.Status(accountID)
+}
+
+// Set saves accountID's chosen status and tells every subscribed hub, here
+// and on the other instances.
+func (s *StatusStore) Set(accountID int, status, text string) error {
+	if err := s.accounts.SetStatus(accountID, status, text); err != nil {
+		return fmt.Errorf("saving status of account %d: %w", accountID, err)
+	}
+	s.notify(accountID, status, text)
+
+	s.mu.Lock()
+	bus, instance := s.bus, s.instance
+	s.mu.Unlock()
+	if bus == nil {
+		return nil
+	}
+	payload, err := json.Marshal(statusEvent{Instance: instance, AccountID: accountID, Status: status, Text: text})
+	if err == nil {
+		err = bus.Publish(statusChannel, payload)
+	}
+	if err != nil {
+		log.Printf("❌ Publishing the status of account %d: %v", accountID, err)
+	}
+	return nil
+}
+
+// receive passes a status change published by another instance to the hubs.
+func (s *StatusStore) receive(payload []byte) {
+	var event statusEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		log.Printf("❌ Bad status event: %v", err)
+		return
+	}
+	s.mu.Lock()
+	own := event.Instance == s.instance
+	s.mu.Unlock()
+	if !own {
+		s.notify(event.AccountID, event.Status, event.Text)
+	}
+}
+
+func (s *StatusStore) notify(accountID int, status, text string) {
+	s.mu.Lock()
+	listeners := make([]func(int, string, string), 0, len(s.listeners))
+	for _, fn := range s.listeners {
+		listeners = append(listeners, fn)
+	}
+	s.mu.Unlock()
+	for _, fn := range listeners {
+		fn(accountID, status, text)
+	}
+}
+
+func (s *StatusStore) subscribe(fn func(accountID int, status, text string)) (unsubscribe func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	id := s.nextID
+	s.listeners[id] = fn
+	return func() {
+		s.mu.Lock()
+		delete(s.listeners, id)
+		s.mu.Unlock()
+	}
+}
+
+// presenceEntry is one account's presence in a hub. chosen is the status the
+// account picked; Status is what others see, which turns from online to away
+// after AwayAfter without a move.
+type presenceEntry struct {
+	Presence
+	chosen     string
+	clients    int
+	lastActive time.Time
+}
+
+// setStatus changes what others see, reporting whether anything changed.
+func (e *presenceEntry) setStatus(status string, now time.Time) bool {
+	if e.Status == status {
+		return false
+	}
+	e.Status, e.Since = status, now
+	return true
+}
+
+func (h *Hub) registerPresenceHandlers() {
+	h.Handle("set_status", func() Payload { return &SetStatusMessage{} }, h.handleSetStatus)
+}
+
+func (h *Hub) handleSetStatus(req *Request) error {
+	m := req.Payload.(*SetStatusMessage)
+	return h.statuses.Set(req.Client.AccountID(), m.Status, m.Text)
+}
+
+// joinPresence counts a new client of c's account, announcing the account if
+// it wasn't in the room yet, and sends c everyone present.
+func (h *Hub) joinPresence(c *Client) error {
+	username, status, text, err := h.statuses.Get(c.AccountID())
+	if err != nil {
+		return fmt.Errorf("loading status of account %d: %w", c.AccountID(), err)
+	}
+
+	now := h.clock.Now()
+	h.presenceLock.Lock()
+	e, ok := h.presence[c.AccountID()]
+	if !ok {
+		e = &presenceEntry{
+			Presence: Presence{AccountID: c.AccountID(), Username: username, Status: status, Text: text, Since: now},
+			chosen:   status,
+		}
+		h.presence[c.AccountID()] = e
+	}
+	e.clients++
+	e.lastActive = now
+	joined := e.Presence
+	h.presenceLock.Unlock()
+
+	if !ok {
+		h.announcePresence(joined)
+	}
+	c.Send(WSMessage{Type: "presence_list", Data: h.presenceList()})
+	return nil
+}
+
+// leavePresence drops a client of c's account; the last one to go takes the
+// account offline.
+func (h *Hub) leavePresence(c *Client) {
+	h.presenceLock.Lock()
+	e, ok := h.presence[c.AccountID()]
+	if !ok {
+		h.presenceLock.Unlock()
+		return
+	}
+	e.clients--
+	if e.clients > 0 {
+		h.presenceLock.Unlock()
+		return
+	}
+	delete(h.presence, c.AccountID())
+	e.setStatus(StatusOffline, h.clock.Now())
+	left := e.Presence
+	h.presenceLock.Unlock()
+
+	h.announcePresence(left)
+}
+
+// touchPresence records activity from accountID, bringing it back from
+// automatic away.
+func (h *Hub) touchPresence(accountID int) {
+	now := h.clock.Now()
+	h.presenceLock.Lock()
+	e, ok := h.presence[accountID]
+	if !ok {
+		h.presenceLock.Unlock()
+		return
+	}
+	e.lastActive = now
+	changed := e.chosen == StatusOnline && e.setStatus(StatusOnline, now)
+	p := e.Presence
+	h.presenceLock.Unlock()
+
+	if changed {
+		h.announcePresence(p)
+	}
+}
+
+// checkIdle marks online accounts that haven't moved for AwayAfter as away.
+func (h *Hub) checkIdle() {
+	now := h.clock.Now()
+	var changed []Presence
+	h.presenceLock.Lock()
+	for _, e := range h.presence {
+		if e.chosen == StatusOnline && now.Sub(e.lastActive) >= h.config.AwayAfter && e.setStatus(StatusAway, now) {
+			changed = append(changed, e.Presence)
+		}
+	}
+	h.presenceLock.Unlock()
+
+	for _, p := range changed {
+		h.announcePresence(p)
+	}
+}
+
+// applyStatus is called by the StatusStore when any account changes status.
+func (h *Hub) applyStatus(accountID int, status, text string) {
+	now := h.clock.Now()
+	h.presenceLock.Lock()
+	e, ok := h.presence[accountID]
+	if !ok {
+		h.presenceLock.Unlock()
+		return
+	}
+	e.chosen, e.Text, e.lastActive = status, text, now
+	e.setStatus(status, now)
+	p := e.Presence
+	h.presenceLock.Unlock()
+
+	h.announcePresence(p)
+}
+
+// announcePresence tells the room about a change to p's account on this
+// instance. Every instance then shows its clients the account as all of
+// them together see it.
+func (h *Hub) announcePresence(p Presence) {
+	h.publish(roomEvent{Presence: &p})
+	h.showPresence(p)
+}
+
+// receivePresence records a change to p's account on another instance.
+func (h *Hub) receivePresence(instance string, p Presence) {
+	h.presenceLock.Lock()
+	if p.Status == StatusOffline {
+		delete(h.remotePresence[p.AccountID], instance)
+		if len(h.remotePresence[p.AccountID]) == 0 {
+			delete(h.remotePresence, p.AccountID)
+		}
+	} else {
+		if h.remotePresence[p.AccountID] == nil {
+			h.remotePresence[p.AccountID] = make(map[string]Presence)
+		}
+		h.remotePresence[p.AccountID][instance] = p
+	}
+	h.presenceLock.Unlock()
+	h.showPresence(p)
+}
+
+// republishPresence announces every account present here again, for an
+// instance that just opened the room.
+func (h *Hub) republishPresence() {
+	h.presenceLock.Lock()
+	present := make([]Presence, 0, len(h.presence))
+	for _, e := range h.presence {
+		present = append(present, e.Presence)
+	}
+	h.presenceLock.Unlock()
+	for _, p := range present {
+		h.publish(roomEvent{Presence: &p})
+	}
+}
+
+// showPresence broadcasts to this instance's clients how p's account is seen
+// across the instances, or p itself if it is present on none.
+func (h *Hub) showPresence(p Presence) {
+	h.presenceLock.Lock()
+	if seen, ok := h.seenPresence(p.AccountID); ok {
+		p = seen
+	}
+	h.presenceLock.Unlock()
+	h.broadcastLocal(WSMessage{Type: "presence", Data: p})
+}
+
+// seenPresence merges accountID's presence here and on the other instances.
+// Online wins over away, so an account is only away once it is idle
+// everywhere. Callers hold h.presenceLock.
+func (h *Hub) seenPresence(accountID int) (Presence, bool) {
+	var seen Presence
+	found := false
+	consider := func(p Presence) {
+		if !found || (seen.Status == StatusAway && p.Status != StatusAway) {
+			seen, found = p, true
+		}
+	}
+	if e, ok := h.presence[accountID]; ok {
+		consider(e.Presence)
+	}
+	for _, p := range h.remotePresence[accountID] {
+		consider(p)
+	}
+	return seen, found
+}
+
+// presenceList returns every account present in the room on any instance,
+// by username.
+func (h *Hub) presenceList() []Presence {
+	h.presenceLock.Lock()
+	list := make([]Presence, 0, len(h.presence)+len(h.remotePresence))
+	for id := range h.presence {
+		p, _ := h.seenPresence(id)
+		list = append(list, p)
+	}
+	for id := range h.remotePresence {
+		if _, ok := h.presence[id]; !ok {
+			p, _ := h.seenPresence(id)
+			list = append(list, p)
+		}
+	}
+	h.presenceLock.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+	return list
+}
+
+// Presence lists the accounts connected to the room named slug. Rooms nobody
+// has opened since startup are empty.
+func (m *RoomManager) Presence(slug string) ([]Presence, error) {
+	m.mu.Lock()
+	h, ok := m.hubs[slug]
+	m.mu.Unlock()
+	if ok {
+		return h.presenceList(), nil
+	}
+	if _, err := m.load(slug); err != nil {
+		return nil, err
+	}
+	return []Presence{}, nil
+}
+
+// PresenceHandler serves GET /presence?room=<slug>, the accounts online in a
+// room, defaulting to the lobby.
+func PresenceHandler(rooms *RoomManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		slug := r.URL.Query().Get("room")
+		if slug == "" {
+			slug = DefaultRoom
+		}
+		list, err := rooms.Presence(slug)
+		if errors.Is(err, ErrRoomNotFound) {
+			writeJSONError(w, http.StatusNotFound, "Room not found")
+			return
+		}
+		if err != nil {
+			log.Printf("❌ Error listing presence for room %q: %v", slug, err)
+			writeJSONError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	ProjectileBullet = "bullet"
+	ProjectileMedkit = "medkit"
+
+	// Speeds match the old client-side animation: 15px and 12px per 16ms.
+	bulletSpeed = 937.5
+	medkitSpeed = 750.0
+
+	projectileLifetime = 3 * time.Second
+)
+
+// Projectile is a bullet or med kit in flight. Positions are in world pixels
+// and velocities in pixels per second.
+type Projectile struct {
+	ID     int64   `json:"id"`
+	Kind   string  `json:"kind"`
+	FromID int     `json:"fromId"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	VX     float64 `json:"vx"`
+	VY     float64 `json:"vy"`
+	Range  float64 `json:"range"` // distance left before it despawns
+
+	movedAt   time.Time
+	expiresAt time.Time
+}
+
+// ProjectileHit reports a projectile reaching a player.
+type ProjectileHit struct {
+	Projectile Projectile
+	PlayerID   int
+}
+
+// ProjectileSim owns every projectile in flight. Each Step moves them along
+// their velocity, sweeping the path travelled since the last step against
+// player hitboxes so fast projectiles can't tunnel through anyone.
+type ProjectileSim struct {
+	clock   Clock
+	players func() []Player
+	emit    func(WSMessage)
+	onHit   func(ProjectileHit)
+
+	mu          sync.Mutex
+	projectiles map[int64]*Projectile
+	nextID      int64
+}
+
+// NewProjectileSim creates a simulation that reads hitboxes from players,
+// sends spawn/hit/despawn events through emit and reports hits to onHit.
+func NewProjectileSim(clock Clock, players func() []Player, emit func(WSMessage), onHit func(ProjectileHit)) *ProjectileSim {
+	return &ProjectileSim{
+		clock:       clock,
+		players:     players,
+		emit:        emit,
+		onHit:       onHit,
+		projectiles: make(map[int64]*Projectile),
+	}
+}
+
+// Spawn fires a projectile of kind from the centre of from towards
+// (targetX, targetY). It reports false if the target is the shooter's centre.
+func (s *ProjectileSim) Spawn(kind string, from Player, targetX, targetY float64) (Projectile, bool) {
+	startX := float64(from.X) + playerSize/2
+	startY := float64(from.Y) + playerSize/2
+	dx, dy := targetX-startX, targetY-startY
+	dist := math.Hypot(dx, dy)
+	if dist == 0 {
+		return Projectile{}, false
+	}
+
+	speed := bulletSpeed
+	if kind == ProjectileMedkit {
+		speed = medkitSpeed
+	}
+	now := s.clock.Now()
+
+	s.mu.Lock()
+	s.nextID++
+	p := &Projectile{
+		ID:        s.nextID,
+		Kind:      kind,
+		FromID:    from.ID,
+		X:         startX,
+		Y:         startY,
+		VX:        dx / dist * speed,
+		VY:        dy / dist * speed,
+		Range:     dist + projectileOvershoot,
+		movedAt:   now,
+		expiresAt: now.Add(projectileLifetime),
+	}
+	s.projectiles[p.ID] = p
+	spawned := *p
+	s.mu.Unlock()
+
+	s.emit(WSMessage{Type: "projectile_spawn", Data: spawned})
+	return spawned, true
+}
+
+// Len returns the number of projectiles in flight.
+func (s *ProjectileSim) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.projectiles)
+}
+
+// Step advances every projectile to the clock's current time.
+func (s *ProjectileSim) Step() {
+	now := s.clock.Now()
+	players := s.players()
+
+	var events []WSMessage
+	var hits []ProjectileHit
+
+	s.mu.Lock()
+	for id, p := range s.projectiles {
+		dt := now.Sub(p.movedAt).Seconds()
+		p.movedAt = now
+		speed := math.Hypot(p.VX, p.VY)
+		travel := math.Min(speed*dt, p.Range)
+		dx, dy := p.VX/speed*travel, p.VY/speed*travel
+
+		if target, t := firstHitAlong(p.FromID, p.X, p.Y, dx, dy, players); target != 0 {
+			p.X += dx * t
+			p.Y += dy * t
+			delete(s.projectiles, id)
+			hits = append(hits, ProjectileHit{Projectile: *p, PlayerID: target})
+			events = append(events, WSMessage{Type: "projectile_hit", Data: map[string]interface{}{
+				"id": p.ID, "kind": p.Kind, "fromId": p.FromID, "playerId": target, "x": p.X, "y": p.Y,
+			}})
+			continue
+		}
+
+		p.X += dx
+		p.Y += dy
+		p.Range -= travel
+		if p.Range <= 0 || !now.Before(p.expiresAt) {
+			delete(s.projectiles, id)
+			events = append(events, WSMessage{Type: "projectile_despawn", Data: map[string]interface{}{
+				"id": p.ID, "x": p.X, "y": p.Y,
+			}})
+		}
+	}
+	s.mu.Unlock()
+
+	for _, ev := range events {
+		s.emit(ev)
+	}
+	for _, hit := range hits {
+		s.onHit(hit)
+	}
+}
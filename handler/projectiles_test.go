@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// projectileTest is a ProjectileSim on a fake clock that records what it
+// emits and hits.
+type projectileTest struct {
+	clock   *FakeClock
+	sim     *ProjectileSim
+	players []Player
+	events  []WSMessage
+	hits    []ProjectileHit
+}
+
+func newProjectileTest(players ...Player) *projectileTest {
+	pt := &projectileTest{clock: NewFakeClock(time.Unix(1000, 0)), players: players}
+	pt.sim = NewProjectileSim(pt.clock,
+		func() []Player { return pt.players },
+		func(msg WSMessage) { pt.events = append(pt.events, msg) },
+		func(hit ProjectileHit) { pt.hits = append(pt.hits, hit) })
+	return pt
+}
+
+// step advances the clock by d and steps the simulation.
+func (pt *projectileTest) step(d time.Duration) {
+	pt.clock.Advance(d)
+	pt.sim.Step()
+}
+
+func (pt *projectileTest) lastEvent() WSMessage {
+	return pt.events[len(pt.events)-1]
+}
+
+// The shooter stands at the origin, so shots start from (30, 30).
+var shooter = Player{ID: 1, X: 0, Y: 0}
+
+func TestProjectileHit(t *testing.T) {
+	target := Player{ID: 2, X: 300, Y: 0}
+	pt := newProjectileTest(shooter, target)
+	p, ok := pt.sim.Spawn(ProjectileBullet, shooter, 330, 30)
+	if !ok {
+		t.Fatal("Spawn refused")
+	}
+	if pt.lastEvent().Type != "projectile_spawn" || p.VX != bulletSpeed || p.VY != 0 {
+		t.Fatalf("spawned %+v, event %s", p, pt.lastEvent().Type)
+	}
+
+	// 187.5px in: still short of the target at x=300.
+	pt.step(200 * time.Millisecond)
+	if len(pt.hits) != 0 || pt.sim.Len() != 1 {
+		t.Fatalf("hit too early: %+v", pt.hits)
+	}
+
+	pt.step(200 * time.Millisecond)
+	if len(pt.hits) != 1 {
+		t.Fatalf("got %d hits, want 1", len(pt.hits))
+	}
+	hit := pt.hits[0]
+	if hit.PlayerID != target.ID || hit.Projectile.FromID != shooter.ID {
+		t.Errorf("hit = %+v", hit)
+	}
+	if math.Abs(hit.Projectile.X-300) > 1e-9 {
+		t.Errorf("hit at x=%v, want the target's edge at 300", hit.Projectile.X)
+	}
+	if ev := pt.lastEvent(); ev.Type != "projectile_hit" {
+		t.Errorf("last event %s, want projectile_hit", ev.Type)
+	}
+	if pt.sim.Len() != 0 {
+		t.Errorf("%d projectiles left after the hit", pt.sim.Len())
+	}
+}
+
+func TestProjectileMissDespawnsAtRange(t *testing.T) {
+	bystander := Player{ID: 2, X: 300, Y: 500}
+	pt := newProjectileTest(shooter, bystander)
+	pt.sim.Spawn(ProjectileBullet, shooter, 330, 30)
+
+	// Range is the 300px to the target plus the overshoot: 0.64s of flight.
+	for i := 0; i < 6; i++ {
+		pt.step(100 * time.Millisecond)
+	}
+	if pt.sim.Len() != 1 {
+		t.Fatal("despawned before using up its range")
+	}
+	pt.step(100 * time.Millisecond)
+
+	if len(pt.hits) != 0 {
+		t.Errorf("missed shot hit %+v", pt.hits)
+	}
+	if pt.sim.Len() != 0 {
+		t.Fatal("still flying after its range")
+	}
+	ev := pt.lastEvent()
+	if ev.Type != "projectile_despawn" {
+		t.Fatalf("last event %s, want projectile_despawn", ev.Type)
+	}
+	if x := ev.Data.(map[string]interface{})["x"].(float64); math.Abs(x-(30+300+projectileOvershoot)) > 1e-9 {
+		t.Errorf("despawned at x=%v, want %v", x, 30+300+projectileOvershoot)
+	}
+}
+
+func TestProjectileDespawnsAfterLifetime(t *testing.T) {
+	pt := newProjectileTest(shooter)
+	// Far enough that its range outlasts its lifetime.
+	pt.sim.Spawn(ProjectileMedkit, shooter, 30+5000, 30)
+
+	step := 500 * time.Millisecond
+	for elapsed := step; elapsed < projectileLifetime; elapsed += step {
+		pt.step(step)
+		if pt.sim.Len() != 1 {
+			t.Fatalf("despawned after %s, before its lifetime", elapsed)
+		}
+	}
+	pt.step(step)
+	if pt.sim.Len() != 0 || pt.lastEvent().Type != "projectile_despawn" {
+		t.Errorf("still flying after its lifetime, last event %s", pt.lastEvent().Type)
+	}
+}
+
+func TestFastProjectileDoesNotTunnel(t *testing.T) {
+	// One long step carries the bullet 937.5px, from well before the target
+	// to well past it; only sweeping the path catches the hit.
+	target := Player{ID: 2, X: 500, Y: 0}
+	pt := newProjectileTest(shooter, target)
+	pt.sim.Spawn(ProjectileBullet, shooter, 2000, 30)
+
+	pt.step(time.Second)
+	if len(pt.hits) != 1 || pt.hits[0].PlayerID != target.ID {
+		t.Fatalf("hits = %+v, want the target in the bullet's path", pt.hits)
+	}
+	if x := pt.hits[0].Projectile.X; math.Abs(x-500) > 1e-9 {
+		t.Errorf("hit at x=%v, want the target's edge at 500", x)
+	}
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// Peers stay connected until they are this much further apart than the
+// radius they met at, so players standing on the edge don't flap in and out.
+const proximityHysteresis = 1.2
+
+// PeerEvent tells a player another player came into or left call range.
+// Initiator is set on exactly one side of each pair: that side sends the
+// rtc_offer.
+type PeerEvent struct {
+	PeerID    int  `json:"peerId"`
+	Initiator bool `json:"initiator"`
+}
+
+// peerPair is two player IDs with A < B.
+type peerPair struct{ A, B int }
+
+func newPeerPair(a, b int) peerPair {
+	if a > b {
+		a, b = b, a
+	}
+	return peerPair{A: a, B: b}
+}
+
+// ProximityTracker keeps the set of player pairs close enough to call each
+// other. Each Step compares positions from players against the last step and
+// sends peer_enter and peer_leave through notify, addressed by player ID.
+type ProximityTracker struct {
+	radius  float64
+	players func() []Player
+	notify  func(playerID int, msg WSMessage)
+
+	mu    sync.Mutex
+	pairs map[peerPair]struct{}
+}
+
+// NewProximityTracker creates a tracker for the players returned by players,
+// normally only those some client of this instance is controlling: calls
+// aren't set up between instances.
+func NewProximityTracker(radius float64, players func() []Player, notify func(playerID int, msg WSMessage)) *ProximityTracker {
+	return &ProximityTracker{
+		radius:  radius,
+		players: players,
+		notify:  notify,
+		pairs:   make(map[peerPair]struct{}),
+	}
+}
+
+// Near reports whether players a and b are currently in call range.
+func (t *ProximityTracker) Near(a, b int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.pairs[newPeerPair(a, b)]
+	return ok
+}
+
+// Step recomputes which pairs are in range and notifies both sides of every
+// pair that entered or left. Players missing from players have left every pair.
+// Each player is only compared with those in the grid cells within reach of
+// it, not with everyone.
+func (t *ProximityTracker) Step() {
+	players := t.players()
+	reach := int(math.Ceil(t.radius * proximityHysteresis))
+	grid := newSpatialGrid(max(reach, 1))
+	byID := make(map[int]Player, len(players))
+	for _, p := range players {
+		grid.set(p.ID, p.X, p.Y)
+		byID[p.ID] = p
+	}
+
+	t.mu.Lock()
+	next := make(map[peerPair]struct{}, len(t.pairs))
+	var entered, left []peerPair
+	for _, p := range players {
+		grid.query(rect{p.X - reach, p.Y - reach, p.X + reach, p.Y + reach}, func(id int) {
+			if id <= p.ID {
+				return // each pair is checked from its lower ID
+			}
+			q := byID[id]
+			pair := newPeerPair(p.ID, q.ID)
+			_, was := t.pairs[pair]
+			limit := t.radius
+			if was {
+				limit *= proximityHysteresis
+			}
+			if !withinRadius(p.X, p.Y, q.X, q.Y, limit) {
+				return
+			}
+			next[pair] = struct{}{}
+			if !was {
+				entered = append(entered, pair)
+			}
+		})
+	}
+	for pair := range t.pairs {
+		if _, ok := next[pair]; !ok {
+			left = append(left, pair)
+		}
+	}
+	t.pairs = next
+	t.mu.Unlock()
+
+	sortPairs(entered)
+	sortPairs(left)
+	for _, pair := range entered {
+		t.notify(pair.A, WSMessage{Type: "peer_enter", Data: PeerEvent{PeerID: pair.B, Initiator: true}})
+		t.notify(pair.B, WSMessage{Type: "peer_enter", Data: PeerEvent{PeerID: pair.A}})
+	}
+	for _, pair := range left {
+		t.notify(pair.A, WSMessage{Type: "peer_leave", Data: PeerEvent{PeerID: pair.B}})
+		t.notify(pair.B, WSMessage{Type: "peer_leave", Data: PeerEvent{PeerID: pair.A}})
+	}
+}
+
+func sortPairs(pairs []peerPair) {
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].A != pairs[j].A {
+			return pairs[i].A < pairs[j].A
+		}
+		return pairs[i].B < pairs[j].B
+	})
+}
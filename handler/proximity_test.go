@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+// proximityTest is a ProximityTracker over players it owns, recording what
+// it sends each player.
+type proximityTest struct {
+	tracker *ProximityTracker
+	players []Player
+	sent    map[int][]WSMessage
+}
+
+func newProximityTest(radius float64, players ...Player) *proximityTest {
+	pt := &proximityTest{players: players, sent: make(map[int][]WSMessage)}
+	pt.tracker = NewProximityTracker(radius,
+		func() []Player { return append([]Player(nil), pt.players...) },
+		func(id int, msg WSMessage) { pt.sent[id] = append(pt.sent[id], msg) })
+	return pt
+}
+
+// step moves player i to x and steps the tracker, returning what each player
+// was sent.
+func (pt *proximityTest) step(i, x int) map[int][]WSMessage {
+	pt.players[i].X = x
+	pt.sent = make(map[int][]WSMessage)
+	pt.tracker.Step()
+	return pt.sent
+}
+
+func TestProximityEnterAndLeave(t *testing.T) {
+	pt := newProximityTest(250, Player{ID: 1}, Player{ID: 2, X: 400})
+	if sent := pt.step(1, 400); len(sent) != 0 {
+		t.Fatalf("players out of range were sent %+v", sent)
+	}
+
+	sent := pt.step(1, 250)
+	if len(sent[1]) != 1 || len(sent[2]) != 1 {
+		t.Fatalf("entering range sent %+v", sent)
+	}
+	if sent[1][0].Type != "peer_enter" || sent[1][0].Data != (PeerEvent{PeerID: 2, Initiator: true}) {
+		t.Errorf("player 1 got %+v, want to initiate with 2", sent[1][0])
+	}
+	if sent[2][0].Type != "peer_enter" || sent[2][0].Data != (PeerEvent{PeerID: 1}) {
+		t.Errorf("player 2 got %+v, want to answer 1", sent[2][0])
+	}
+	if !pt.tracker.Near(2, 1) {
+		t.Error("Near(2, 1) is false after they met")
+	}
+
+	sent = pt.step(1, 1000)
+	if len(sent[1]) != 1 || sent[1][0].Type != "peer_leave" || sent[1][0].Data != (PeerEvent{PeerID: 2}) {
+		t.Errorf("player 1 got %+v on leaving range", sent[1])
+	}
+	if len(sent[2]) != 1 || sent[2][0].Type != "peer_leave" || sent[2][0].Data != (PeerEvent{PeerID: 1}) {
+		t.Errorf("player 2 got %+v on leaving range", sent[2])
+	}
+	if pt.tracker.Near(1, 2) {
+		t.Error("Near(1, 2) is true after they parted")
+	}
+}
+
+func TestProximityHysteresis(t *testing.T) {
+	pt := newProximityTest(250, Player{ID: 1}, Player{ID: 2, X: 250})
+	pt.step(1, 250)
+
+	// Past the radius they met at but within the hysteresis margin.
+	edge := int(250 * proximityHysteresis)
+	if sent := pt.step(1, edge); len(sent) != 0 || !pt.tracker.Near(1, 2) {
+		t.Fatalf("drifting to %d sent %+v", edge, sent)
+	}
+	if sent := pt.step(1, edge+1); len(sent[1]) != 1 || sent[1][0].Type != "peer_leave" {
+		t.Fatalf("leaving the margin sent %+v", sent)
+	}
+
+	// Coming back inside the margin isn't enough to meet again.
+	if sent := pt.step(1, edge); len(sent) != 0 || pt.tracker.Near(1, 2) {
+		t.Fatalf("returning to %d sent %+v", edge, sent)
+	}
+	if sent := pt.step(1, 250); len(sent[1]) != 1 || sent[1][0].Type != "peer_enter" {
+		t.Fatalf("returning within the radius sent %+v", sent)
+	}
+}
+
+func TestProximityLeavesPlayersWhoGo(t *testing.T) {
+	pt := newProximityTest(250, Player{ID: 1}, Player{ID: 2, X: 100})
+	pt.step(1, 100)
+
+	pt.players = pt.players[:1]
+	sent := pt.step(0, 0)
+	if len(sent[1]) != 1 || sent[1][0].Type != "peer_leave" || sent[1][0].Data != (PeerEvent{PeerID: 2}) {
+		t.Errorf("player 1 got %+v when player 2 went", sent[1])
+	}
+}
+
+func TestProximityFindsTheSamePairsAsComparingEveryone(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	players := make([]Player, 200)
+	for i := range players {
+		players[i] = Player{ID: i + 1, X: rng.IntN(4000) - 2000, Y: rng.IntN(4000) - 2000}
+	}
+	pt := newProximityTest(250, players...)
+	for round := 0; round < 5; round++ {
+		for i := range pt.players {
+			pt.players[i].X += rng.IntN(201) - 100
+			pt.players[i].Y += rng.IntN(201) - 100
+		}
+		was := make(map[peerPair]struct{}, len(pt.tracker.pairs))
+		for pair := range pt.tracker.pairs {
+			was[pair] = struct{}{}
+		}
+		pt.tracker.Step()
+
+		for i, p := range pt.players {
+			for _, q := range pt.players[i+1:] {
+				limit := 250.0
+				if _, ok := was[newPeerPair(p.ID, q.ID)]; ok {
+					limit *= proximityHysteresis
+				}
+				if want := withinRadius(p.X, p.Y, q.X, q.Y, limit); pt.tracker.Near(p.ID, q.ID) != want {
+					t.Fatalf("round %d: Near(%d, %d) = %v, want %v", round, p.ID, q.ID, !want, want)
+				}
+			}
+		}
+	}
+}
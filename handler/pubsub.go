@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// PubSub carries room events between server instances, so clients of the same
+// room connected to different instances still see one world.
+type PubSub interface {
+	// Publish sends payload to every subscriber of channel, including ones in
+	// this process.
+	Publish(channel string, payload []byte) error
+
+	// Subscribe calls fn with every payload published to channel until the
+	// returned function is called.
+	Subscribe(channel string, fn func(payload []byte)) (unsubscribe func(), err error)
+}
+
+// NewInstanceID returns a random ID for this server process. Hubs tag what
+// they publish with it and ignore their own events when they come back.
+func NewInstanceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// MemoryPubSub delivers events within one process. It is all a single
+// instance needs.
+type MemoryPubSub struct {
+	mu     sync.Mutex
+	next   int
+	topics map[string]map[int]func([]byte)
+}
+
+func NewMemoryPubSub() *MemoryPubSub {
+	return &MemoryPubSub{topics: make(map[string]map[int]func([]byte))}
+}
+
+func (ps *MemoryPubSub) Publish(channel string, payload []byte) error {
+	ps.mu.Lock()
+	subs := make([]func([]byte), 0, len(ps.topics[channel]))
+	for _, fn := range ps.topics[channel] {
+		subs = append(subs, fn)
+	}
+	ps.mu.Unlock()
+	for _, fn := range subs {
+		fn(payload)
+	}
+	return nil
+}
+
+func (ps *MemoryPubSub) Subscribe(channel string, fn func([]byte)) (func(), error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.next++
+	id := ps.next
+	if ps.topics[channel] == nil {
+		ps.topics[channel] = make(map[int]func([]byte))
+	}
+	ps.topics[channel][id] = fn
+	return func() {
+		ps.mu.Lock()
+		defer ps.mu.Unlock()
+		delete(ps.topics[channel], id)
+		if len(ps.topics[channel]) == 0 {
+			delete(ps.topics, channel)
+		}
+	}, nil
+}
+
+// maxRelayedMoves keeps each published batch of moves within what every
+// backend can carry; larger ticks are split.
+const maxRelayedMoves = 150
+
+// roomEvent is what a hub publishes: a broadcast for the other instances'
+// clients, and the changes to the room's players they need to mirror.
+type roomEvent struct {
+	Instance string           `json:"instance"`
+	Message  *WSMessage       `json:"message,omitempty"`
+	Chat     *nearbyChat      `json:"chat,omitempty"`    // for listeners near the speaker only
+	Player   *Player          `json:"player,omitempty"`  // created or changed
+	Removed  int              `json:"removed,omitempty"` // deleted player ID
+	Moves    []PlayerPosition `json:"moves,omitempty"`
+	Presence *Presence        `json:"presence,omitempty"` // an account's presence on the sender
+	// SyncPresence asks the other instances to publish who is present on
+	// them, for a hub that just opened the room.
+	SyncPresence bool `json:"syncPresence,omitempty"`
+}
+
+func roomChannel(slug string) string {
+	return "ourgatther_room_" + slug
+}
+
+func (h *Hub) publish(event roomEvent) {
+	event.Instance = h.config.InstanceID
+	payload, err := json.Marshal(event)
+	if err == nil {
+		err = h.bus.Publish(roomChannel(h.room.Slug), payload)
+	}
+	if err != nil {
+		log.Printf("❌ Publishing to room %q: %v", h.room.Slug, err)
+	}
+}
+
+// publishPlayer sends the current state of player id to the other instances.
+func (h *Hub) publishPlayer(id int) {
+	if p, ok := h.world.get(id); ok {
+		h.publish(roomEvent{Player: &p})
+	}
+}
+
+// receive applies an event published by another instance: player changes go
+// into the local world, so the next tick shows them to the clients that can
+// see them, broadcasts go to every local client, chat to those in earshot and
+// presence into the room's list of who is online.
+func (h *Hub) receive(payload []byte) {
+	var event roomEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		log.Printf("❌ Bad event for room %q: %v", h.room.Slug, err)
+		return
+	}
+	if event.Instance == h.config.InstanceID {
+		return
+	}
+	if event.Player != nil {
+		h.world.add(*event.Player)
+	}
+	if event.Removed != 0 {
+		h.forgetPlayer(event.Removed)
+		h.world.remove(event.Removed)
+	}
+	for _, m := range event.Moves {
+		h.world.place(m)
+	}
+	if event.Message != nil {
+		h.broadcastLocal(*event.Message)
+	}
+	if event.Chat != nil {
+		h.sayNearby(event.Chat.message(), nil)
+	}
+	if event.Presence != nil {
+		h.receivePresence(event.Instance, *event.Presence)
+	}
+	if event.SyncPresence {
+		h.republishPresence()
+	}
+}
+
+// startRelay subscribes the hub to its room's events from other instances.
+func (h *Hub) startRelay() error {
+	unsubscribe, err := h.bus.Subscribe(roomChannel(h.room.Slug), h.receive)
+	if err != nil {
+		return fmt.Errorf("subscribing to room %q: %w", h.room.Slug, err)
+	}
+	h.unsubscribeBus = unsubscribe
+	h.publish(roomEvent{SyncPresence: true})
+	return nil
+}
@@ -0,0 +1,265 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+// newRelayedHubs starts two hubs for the lobby, as two instances sharing one
+// store and one bus, with the given players in the room.
+func newRelayedHubs(t *testing.T, players ...string) (a, b *Hub, ids []int) {
+	t.Helper()
+	store := NewMemoryStore()
+	lobby := mustLobby(t, store)
+	for _, name := range players {
+		ids = append(ids, mustPlayer(t, store, name, 0, lobby.ID))
+	}
+	bus := NewMemoryPubSub()
+	sessions := NewSessionManager(store.Sessions, []byte("secret"), time.Hour)
+	return newRelayedHub(t, store, sessions, bus, lobby), newRelayedHub(t, store, sessions, bus, lobby), ids
+}
+
+// newRelayedHub starts a hub for room as a new instance on bus, with its own
+// direct messenger and status store.
+func newRelayedHub(t *testing.T, store *Store, sessions *SessionManager, bus PubSub, room Room) *Hub {
+	t.Helper()
+	config := DefaultHubConfig()
+	config.Clock = NewFakeClock(time.Unix(1000, 0))
+	dms, statuses := NewDirectMessenger(store.Messages), NewStatusStore(store.Accounts)
+	for _, relay := range []func(PubSub, string) (func(), error){dms.Relay, statuses.Relay} {
+		unsubscribe, err := relay(bus, config.InstanceID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(unsubscribe)
+	}
+	h := NewHub(store, sessions, dms, statuses, bus, config, room)
+	if err := h.Start(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.unsubscribeBus)
+	return h
+}
+
+// addTestClient puts a client looking at the whole room into h without a
+// connection, logged in as accountID; what the hub sends it stays in its
+// queue.
+func addTestClient(t *testing.T, h *Hub, accountID int) *Client {
+	t.Helper()
+	_, sess, err := h.sessions.Issue(accountID, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{
+		codec:   jsonCodec{},
+		session: sess,
+		queue:   newSendQueue(h.config.Backpressure, h.clock, func(string) {}),
+		view:    clientView{width: h.config.WorldWidth * 2, height: h.config.WorldHeight * 2},
+	}
+	h.lock.Lock()
+	h.clients[c] = true
+	h.lock.Unlock()
+	return c
+}
+
+// received takes the messages of type typ queued for c, dropping the rest.
+func received(c *Client, typ string) []WSMessage {
+	var msgs []WSMessage
+	for _, msg := range c.queue.pop(1 << 10) {
+		if msg.Type == typ {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+func TestMovesReachOtherInstances(t *testing.T) {
+	a, b, ids := newRelayedHubs(t, "mover")
+	watcher := addTestClient(t, b, 2)
+	b.step()
+	if n := len(received(watcher, "player_enter_view")); n != 1 {
+		t.Fatalf("got %d player_enter_view, want 1", n)
+	}
+
+	if _, _, ok := a.world.move(ids[0], 60, 20, a.clock.Now(), a.movement); !ok {
+		t.Fatal("the mover isn't in a's world")
+	}
+	a.step()
+	if p, _ := b.world.get(ids[0]); p.X != 60 {
+		t.Errorf("b has the mover at x=%d, want 60", p.X)
+	}
+	if moves, _ := a.world.drainMoved(); len(moves) != 0 {
+		t.Errorf("a's own moves came back to it: %+v", moves)
+	}
+
+	b.step()
+	ticks := received(watcher, "tick")
+	if len(ticks) != 1 {
+		t.Fatalf("got %d ticks, want 1", len(ticks))
+	}
+	if moves := ticks[0].Data.(TickDelta).Moves; len(moves) != 1 || moves[0] != (PlayerPosition{ID: ids[0], X: 60, Y: 20}) {
+		t.Errorf("tick moves = %+v", moves)
+	}
+	if _, local := b.world.drainMoved(); len(local) != 0 {
+		t.Errorf("b took relayed moves for its own: %+v", local)
+	}
+}
+
+func TestBroadcastsReachOtherInstances(t *testing.T) {
+	a, b, _ := newRelayedHubs(t)
+	sender, other := addTestClient(t, a, 1), addTestClient(t, b, 2)
+
+	a.Broadcast(WSMessage{Type: "announcement", Data: "hello"})
+	if n := len(received(sender, "announcement")); n != 1 {
+		t.Errorf("sender's instance delivered %d copies, want 1", n)
+	}
+	got := received(other, "announcement")
+	if len(got) != 1 {
+		t.Fatalf("other instance delivered %d copies, want 1", len(got))
+	}
+	if got[0].Data != "hello" || got[0].Seq != 1 {
+		t.Errorf("other instance got %+v", got[0])
+	}
+}
+
+func TestPlayerDeletionsReachOtherInstances(t *testing.T) {
+	a, b, ids := newRelayedHubs(t, "doomed")
+	sender := addTestClient(t, a, 1)
+	controller := addTestClient(t, b, 2)
+	controller.controlPlayer(ids[0])
+
+	if err := a.deletePlayer(ids[0], map[string]interface{}{"id": ids[0], "reason": "deleted"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.world.get(ids[0]); ok {
+		t.Error("the player is still in b's world")
+	}
+	if id := controller.ControlledPlayer(); id != 0 {
+		t.Errorf("b's client still controls player %d", id)
+	}
+	if n := len(received(controller, "player_deleted")); n != 1 {
+		t.Errorf("other instance delivered %d player_deleted, want 1", n)
+	}
+	if n := len(received(sender, "player_deleted")); n != 1 {
+		t.Errorf("sender's instance delivered %d player_deleted, want 1", n)
+	}
+}
+
+func TestNearbyChatReachesListenersOnOtherInstances(t *testing.T) {
+	a, b, ids := newRelayedHubs(t, "speaker", "near", "far")
+	speaker := addTestClient(t, a, 1)
+	speaker.controlPlayer(ids[0])
+	near, far := addTestClient(t, b, 2), addTestClient(t, b, 3)
+	near.controlPlayer(ids[1])
+	far.controlPlayer(ids[2])
+	b.world.place(PlayerPosition{ID: ids[2], X: 5000, Y: 5000})
+
+	req := &Request{Type: "chat", Payload: &ChatSendMessage{Text: "psst"}, Client: speaker}
+	if err := a.handleChat(req); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(received(speaker, "chat")); n != 1 {
+		t.Errorf("speaker got %d copies, want 1", n)
+	}
+	got := received(near, "chat")
+	if len(got) != 1 || got[0].Data.(ChatMessage).Text != "psst" {
+		t.Fatalf("listener in earshot got %+v", got)
+	}
+	if n := len(received(far, "chat")); n != 0 {
+		t.Errorf("listener out of earshot got %d messages", n)
+	}
+}
+
+func TestDirectMessagesReachOtherInstances(t *testing.T) {
+	a, b, _ := newRelayedHubs(t)
+	alice, bob := mustAccount(t, a.store, "alice"), mustAccount(t, a.store, "bob")
+	sender, recipient := addTestClient(t, a, alice), addTestClient(t, b, bob)
+	a.dms.connect(sender)
+	b.dms.connect(recipient)
+
+	msg, err := a.dms.Send(alice, bob, "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(received(sender, "dm")); n != 1 {
+		t.Errorf("sender got %d copies, want 1", n)
+	}
+	got := received(recipient, "dm")
+	if len(got) != 1 || got[0].Data.(map[string]interface{})["text"] != "hi" {
+		t.Fatalf("recipient on the other instance got %+v", got)
+	}
+
+	if err := b.dms.MarkRead(bob, alice, msg.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(received(sender, "dm_read")); n != 1 {
+		t.Errorf("sender got %d read receipts, want 1", n)
+	}
+}
+
+// presenceOf returns the last presence of accountID queued for c.
+func presenceOf(c *Client, accountID int) (Presence, bool) {
+	var last Presence
+	found := false
+	for _, msg := range received(c, "presence") {
+		if p, ok := msg.Data.(Presence); ok && p.AccountID == accountID {
+			last, found = p, true
+		}
+	}
+	return last, found
+}
+
+func TestPresenceIsSharedBetweenInstances(t *testing.T) {
+	a, b, _ := newRelayedHubs(t)
+	alice := mustAccount(t, a.store, "alice")
+	watcher := addTestClient(t, b, mustAccount(t, a.store, "watcher"))
+
+	onA := addTestClient(t, a, alice)
+	if err := a.joinPresence(onA); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := presenceOf(watcher, alice); p.Status != StatusOnline {
+		t.Fatalf("watcher saw alice as %q, want online", p.Status)
+	}
+	if list := b.presenceList(); len(list) != 1 || list[0].AccountID != alice {
+		t.Errorf("b's presence list = %+v, want alice", list)
+	}
+
+	// A status set on one instance reaches the other's hubs.
+	onB := addTestClient(t, b, alice)
+	if err := b.joinPresence(onB); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.statuses.Set(alice, StatusBusy, "in a meeting"); err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := presenceOf(watcher, alice); !ok || p.Status != StatusBusy || p.Text != "in a meeting" {
+		t.Errorf("watcher saw alice as %+v, want busy", p)
+	}
+
+	// Leaving one instance doesn't take alice offline while she is on the other.
+	a.leavePresence(onA)
+	if p, _ := presenceOf(watcher, alice); p.Status == StatusOffline {
+		t.Error("alice went offline while still connected to b")
+	}
+	b.leavePresence(onB)
+	if p, _ := presenceOf(watcher, alice); p.Status != StatusOffline {
+		t.Errorf("watcher saw alice as %q after she left, want offline", p.Status)
+	}
+	if list := a.presenceList(); len(list) != 0 {
+		t.Errorf("a's presence list = %+v, want empty", list)
+	}
+}
+
+func TestNewInstancesLearnWhoIsPresent(t *testing.T) {
+	a, _, _ := newRelayedHubs(t)
+	alice := mustAccount(t, a.store, "alice")
+	if err := a.joinPresence(addTestClient(t, a, alice)); err != nil {
+		t.Fatal(err)
+	}
+
+	late := newRelayedHub(t, a.store, a.sessions, a.bus, a.room)
+	if list := late.presenceList(); len(list) != 1 || list[0].AccountID != alice {
+		t.Errorf("presence list of an instance opening the room = %+v, want alice", list)
+	}
+}
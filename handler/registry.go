@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"errors"
+	"log"
+)
+
+// Request is a decoded and validated client message on its way to a handler.
+type Request struct {
+	Type    string
+	Payload Payload
+	Client  *Client
+}
+
+// MessageHandler handles one message type. Returning a *MessageError sends
+// it to the client as an "error" frame; any other error is logged and the
+// client gets a generic internal_error.
+type MessageHandler func(req *Request) error
+
+// Middleware wraps a MessageHandler, e.g. for auth, rate limiting, logging
+// or metrics.
+type Middleware func(next MessageHandler) MessageHandler
+
+type route struct {
+	newPayload func() Payload
+	handle     MessageHandler
+	middleware []Middleware
+}
+
+// Handle registers fn for msgType. newPayload returns the empty payload the
+// message data is strictly decoded into. Route middleware runs inside the
+// hub-wide middleware installed with Use.
+func (h *Hub) Handle(msgType string, newPayload func() Payload, fn MessageHandler, middleware ...Middleware) {
+	h.routesLock.Lock()
+	defer h.routesLock.Unlock()
+	if _, exists := h.routes[msgType]; exists {
+		panic("handler: duplicate handler for message type " + msgType)
+	}
+	h.routes[msgType] = route{newPayload: newPayload, handle: fn, middleware: middleware}
+}
+
+// Use appends middleware that wraps every registered handler.
+func (h *Hub) Use(middleware ...Middleware) {
+	h.routesLock.Lock()
+	h.middleware = append(h.middleware, middleware...)
+	h.routesLock.Unlock()
+}
+
+// dispatch decodes one raw frame from c and runs it through the handler
+// chain registered for its type.
+func (h *Hub) dispatch(c *Client, raw []byte) {
+	env, msgErr := c.codec.decodeEnvelope(raw)
+	if msgErr != nil {
+		log.Println("bad ws message:", msgErr)
+		c.sendError(msgErr)
+		return
+	}
+
+	h.routesLock.RLock()
+	rt, ok := h.routes[env.Type]
+	middleware := h.middleware
+	h.routesLock.RUnlock()
+	if !ok {
+		c.sendError(&MessageError{Code: ErrCodeUnknownType, Type: env.Type, Message: "unknown message type"})
+		return
+	}
+
+	payload, err := c.codec.decodePayload(env.Data, rt.newPayload)
+	if err != nil {
+		c.sendError(&MessageError{Code: ErrCodeInvalidPayload, Type: env.Type, Message: err.Error()})
+		return
+	}
+
+	next := rt.handle
+	for i := len(rt.middleware) - 1; i >= 0; i-- {
+		next = rt.middleware[i](next)
+	}
+	for i := len(middleware) - 1; i >= 0; i-- {
+		next = middleware[i](next)
+	}
+
+	err = next(&Request{Type: env.Type, Payload: payload, Client: c})
+	if err == nil {
+		return
+	}
+	var replyErr *MessageError
+	if errors.As(err, &replyErr) {
+		reply := *replyErr
+		if reply.Type == "" {
+			reply.Type = env.Type
+		}
+		c.sendError(&reply)
+		return
+	}
+	log.Printf("❌ %s handler failed: %v", env.Type, err)
+	c.sendError(&MessageError{Code: ErrCodeInternal, Type: env.Type, Message: "internal server error"})
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"errors"
+	"testing"
+)
+
+type pingPayload struct {
+	N int `json:"n"`
+}
+
+func (p *pingPayload) Validate() error {
+	if p.N < 0 {
+		return errors.New("n must not be negative")
+	}
+	return nil
+}
+
+// dispatchJSON runs a JSON message from c through h's handlers and returns
+// the error c was sent for it, if any.
+func dispatchJSON(t *testing.T, h *Hub, c *Client, msg string) *MessageError {
+	t.Helper()
+	c.queue.pop(1 << 10)
+	h.dispatch(c, []byte(msg))
+	errs := received(c, "error")
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs[0].Data.(*MessageError)
+}
+
+func TestDispatchDecodesStrictly(t *testing.T) {
+	h, _, _ := newRelayedHubs(t)
+	var handled []int
+	h.Handle("ping", func() Payload { return &pingPayload{} }, func(req *Request) error {
+		handled = append(handled, req.Payload.(*pingPayload).N)
+		return nil
+	})
+	c := addTestClient(t, h, 1)
+
+	for _, tt := range []struct {
+		name string
+		msg  string
+		code string // "" if it is handled
+	}{
+		{"valid", `{"type": "ping", "data": {"n": 1}}`, ""},
+		{"no data", `{"type": "ping"}`, ""},
+		{"unknown field", `{"type": "ping", "data": {"n": 1, "extra": true}}`, ErrCodeInvalidPayload},
+		{"wrong field type", `{"type": "ping", "data": {"n": "one"}}`, ErrCodeInvalidPayload},
+		{"fails validation", `{"type": "ping", "data": {"n": -1}}`, ErrCodeInvalidPayload},
+		{"missing type", `{"data": {"n": 1}}`, ErrCodeInvalidPayload},
+		{"empty type", `{"type": "", "data": {"n": 1}}`, ErrCodeInvalidPayload},
+		{"type not a string", `{"type": 7, "data": {"n": 1}}`, ErrCodeBadJSON},
+		{"not JSON", `ping`, ErrCodeBadJSON},
+		{"unknown type", `{"type": "pong", "data": {}}`, ErrCodeUnknownType},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			handled = nil
+			err := dispatchJSON(t, h, c, tt.msg)
+			if tt.code == "" {
+				if err != nil || len(handled) != 1 {
+					t.Fatalf("got %v, handled %v; want it handled once", err, handled)
+				}
+				return
+			}
+			if err == nil || err.Code != tt.code {
+				t.Fatalf("got %v, want %s", err, tt.code)
+			}
+			if len(handled) != 0 {
+				t.Errorf("rejected message was handled: %v", handled)
+			}
+		})
+	}
+}
+
+func TestUnknownTypeErrorNamesTheType(t *testing.T) {
+	h, _, _ := newRelayedHubs(t)
+	c := addTestClient(t, h, 1)
+	if err := dispatchJSON(t, h, c, `{"type": "teleport"}`); err == nil || err.Type != "teleport" {
+		t.Errorf("got %+v, want an error for type teleport", err)
+	}
+}
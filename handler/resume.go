@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// Welcome is the first message on every connection. Clients keep the resume
+// token and the highest seq they have seen, and pass both back as ?resume=
+// and ?seq= when they reconnect.
+type Welcome struct {
+	ResumeToken string `json:"resumeToken"`
+	Seq         uint64 `json:"seq"`
+	Resumed     bool   `json:"resumed"`
+}
+
+// parkedClient remembers a disconnected client for ResumeGrace.
+type parkedClient struct {
+	accountID int
+	playerID  int
+	heardChat int64
+	expires   time.Time
+}
+
+// replayBuffer holds the room's most recent broadcasts, indexed by seq.
+type replayBuffer struct {
+	msgs []WSMessage
+	last uint64
+}
+
+func newReplayBuffer(size int) *replayBuffer {
+	return &replayBuffer{msgs: make([]WSMessage, size)}
+}
+
+func (b *replayBuffer) add(msg WSMessage) {
+	b.msgs[msg.Seq%uint64(len(b.msgs))] = msg
+	b.last = msg.Seq
+}
+
+// since returns every broadcast after seq, or false if some of them have
+// already been overwritten.
+func (b *replayBuffer) since(seq uint64) ([]WSMessage, bool) {
+	if seq > b.last {
+		return nil, false
+	}
+	if b.last-seq > uint64(len(b.msgs)) {
+		return nil, false
+	}
+	missed := make([]WSMessage, 0, b.last-seq)
+	for s := seq + 1; s <= b.last; s++ {
+		missed = append(missed, b.msgs[s%uint64(len(b.msgs))])
+	}
+	return missed, true
+}
+
+func newResumeToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// resumeRequest reads ?resume= and ?seq= from a reconnecting client.
+func resumeRequest(token, seq string) (string, uint64, bool) {
+	if token == "" {
+		return "", 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return token, n, true
+}
+
+// park keeps c resumable for ResumeGrace after it disconnects. Callers hold h.lock.
+func (h *Hub) park(c *Client) {
+	now := h.clock.Now()
+	for token, p := range h.parked {
+		if now.After(p.expires) {
+			delete(h.parked, token)
+		}
+	}
+	h.parked[c.resumeToken] = parkedClient{
+		accountID: c.AccountID(),
+		playerID:  c.ControlledPlayer(),
+		heardChat: c.lastHeard(),
+		expires:   now.Add(h.config.ResumeGrace),
+	}
+}
+
+// unpark claims a parked client for accountID, giving back the player it
+// was controlling and how far it had got with the chat.
+func (h *Hub) unpark(token string, accountID int) (parkedClient, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	p, ok := h.parked[token]
+	if !ok || p.accountID != accountID || h.clock.Now().After(p.expires) {
+		return parkedClient{}, false
+	}
+	delete(h.parked, token)
+	return p, true
+}
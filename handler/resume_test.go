@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// testFrame is a message as a JSON client reads it.
+type testFrame struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// dialHub connects to the hub served at url as the holder of token.
+func dialHub(t *testing.T, url, token string) *websocket.Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(url, "http"), &websocket.DialOptions{
+		HTTPHeader: http.Header{"Authorization": {"Bearer " + token}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	return conn
+}
+
+// readFrames reads messages from conn, unpacking batches, until one of type
+// typ arrives, and returns the last of each type read.
+func readFrames(t *testing.T, conn *websocket.Conn, typ string) map[string]testFrame {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	frames := make(map[string]testFrame)
+	for {
+		_, raw, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", typ, err)
+		}
+		var frame testFrame
+		if err := json.Unmarshal(raw, &frame); err != nil {
+			t.Fatal(err)
+		}
+		batch := []testFrame{frame}
+		if frame.Type == "batch" {
+			batch = nil
+			if err := json.Unmarshal(frame.Data, &batch); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, f := range batch {
+			frames[f.Type] = f
+		}
+		if _, ok := frames[typ]; ok {
+			return frames
+		}
+	}
+}
+
+func TestResumeCatchesUpOnChatAndDMs(t *testing.T) {
+	store := NewMemoryStore()
+	lobby := mustLobby(t, store)
+	alice, bob := mustAccount(t, store, "alice"), mustAccount(t, store, "bob")
+	alicePlayer := mustPlayer(t, store, "alice", alice, lobby.ID)
+	bobPlayer := mustPlayer(t, store, "bob", bob, lobby.ID)
+	if err := store.Accounts.SetLastPlayer(alice, &alicePlayer); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := NewSessionManager(store.Sessions, []byte("secret"), time.Hour)
+	h := NewHub(store, sessions, NewDirectMessenger(store.Messages), NewStatusStore(store.Accounts),
+		NewMemoryPubSub(), DefaultHubConfig(), lobby)
+	if err := h.Start(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(h.WebSocketHandler))
+	defer server.Close()
+	token, _, err := sessions.Issue(alice, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := dialHub(t, server.URL, token)
+	var welcome Welcome
+	if err := json.Unmarshal(readFrames(t, conn, "dm_unread")["welcome"].Data, &welcome); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close(websocket.StatusGoingAway, "")
+	for deadline := time.Now().Add(5 * time.Second); h.ClientCount() > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the hub never noticed the client left")
+		}
+	}
+
+	// While alice is away, bob talks to her and messages her.
+	speaker := addTestClient(t, h, bob)
+	speaker.controlPlayer(bobPlayer)
+	if err := h.handleChat(&Request{Type: "chat", Payload: &ChatSendMessage{Text: "you there?"}, Client: speaker}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.dms.Send(bob, alice, "call me"); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed := dialHub(t, server.URL+"?resume="+welcome.ResumeToken+"&seq="+strconv.FormatUint(welcome.Seq, 10), token)
+	got := readFrames(t, resumed, "dm_unread")
+	if err := json.Unmarshal(got["welcome"].Data, &welcome); err != nil || !welcome.Resumed {
+		t.Fatalf("welcome = %s, want resumed", got["welcome"].Data)
+	}
+	var chat ChatMessage
+	if err := json.Unmarshal(got["chat"].Data, &chat); err != nil || chat.Text != "you there?" {
+		t.Errorf("missed chat = %s", got["chat"].Data)
+	}
+	var unread []DMUnread
+	if err := json.Unmarshal(got["dm_unread"].Data, &unread); err != nil || len(unread) != 1 || unread[0] != (DMUnread{From: bob, Count: 1}) {
+		t.Errorf("dm_unread = %s", got["dm_unread"].Data)
+	}
+	if _, ok := got["snapshot"]; ok {
+		t.Error("a resumed client was sent a snapshot")
+	}
+}
@@ -0,0 +1,423 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+const (
+	// DefaultRoom is joined when /ws is opened without ?room=. It is created
+	// by the schema setup and can't be renamed or deleted.
+	DefaultRoom = "lobby"
+
+	defaultRoomCapacity = 50
+	maxRoomCapacity     = 500
+
+	// Rooms nobody has been in for emptyRoomTimeout are closed, checked
+	// every emptyRoomCheck; they open again when someone joins. This is
+	// well past ResumeGrace, so dropped clients can still resume.
+	emptyRoomTimeout = 5 * time.Minute
+	emptyRoomCheck   = time.Minute
+)
+
+var (
+	ErrRoomNotFound = errors.New("room not found")
+	ErrRoomExists   = errors.New("room already exists")
+
+	roomSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,31}$`)
+)
+
+type Room struct {
+	ID        int    `json:"id"`
+	Slug      string `json:"slug"`
+	Name      string `json:"name"`
+	Capacity  int    `json:"capacity"`
+	CreatedBy *int   `json:"createdBy"`
+	Online    int    `json:"online"`
+}
+
+// RoomManager owns one Hub per room, started on first use, so players,
+// drawings and broadcasts stay within their room.
+type RoomManager struct {
+	ctx      context.Context
+	store    *Store
+	sessions *SessionManager
+	dms      *DirectMessenger
+	statuses *StatusStore
+	bus      PubSub
+	config   HubConfig
+
+	mu         sync.Mutex
+	hubs       map[string]*Hub
+	emptySince map[string]time.Time
+	middleware []Middleware
+}
+
+// NewRoomManager creates a manager whose rooms run until ctx is done.
+func NewRoomManager(ctx context.Context, store *Store, sessions *SessionManager, dms *DirectMessenger, statuses *StatusStore, bus PubSub, config HubConfig) *RoomManager {
+	m := &RoomManager{
+		ctx:        ctx,
+		store:      store,
+		sessions:   sessions,
+		dms:        dms,
+		statuses:   statuses,
+		bus:        bus,
+		config:     config,
+		hubs:       make(map[string]*Hub),
+		emptySince: make(map[string]time.Time),
+	}
+	go m.closeEmptyRooms(ctx)
+	return m
+}
+
+// closeEmptyRooms closes rooms that stay empty for emptyRoomTimeout, until
+// ctx is done.
+func (m *RoomManager) closeEmptyRooms(ctx context.Context) {
+	ticker := time.NewTicker(emptyRoomCheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.closeEmpty(time.Now())
+		}
+	}
+}
+
+// closeEmpty closes the rooms that have been empty since before
+// emptyRoomTimeout ago, as of now, and notes those that just emptied.
+func (m *RoomManager) closeEmpty(now time.Time) {
+	m.mu.Lock()
+	closing := make(map[string]*Hub)
+	for slug, h := range m.hubs {
+		if h.ClientCount() > 0 {
+			delete(m.emptySince, slug)
+			continue
+		}
+		since, ok := m.emptySince[slug]
+		if !ok {
+			m.emptySince[slug] = now
+			continue
+		}
+		if now.Sub(since) < emptyRoomTimeout {
+			continue
+		}
+		closing[slug] = h
+		delete(m.hubs, slug)
+		delete(m.emptySince, slug)
+	}
+	m.mu.Unlock()
+
+	for slug, h := range closing {
+		h.Close(websocket.StatusGoingAway, "room closed")
+		log.Printf("🚪 Room %q closed, nobody was in it", slug)
+	}
+}
+
+// Use installs middleware on every room's hub, including ones already running.
+func (m *RoomManager) Use(middleware ...Middleware) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.middleware = append(m.middleware, middleware...)
+	for _, h := range m.hubs {
+		h.Use(middleware...)
+	}
+}
+
+// Hub returns the running hub for slug, starting it if needed.
+func (m *RoomManager) Hub(slug string) (*Hub, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if h, ok := m.hubs[slug]; ok {
+		return h, nil
+	}
+
+	room, err := m.load(slug)
+	if err != nil {
+		return nil, err
+	}
+	h := NewHub(m.store, m.sessions, m.dms, m.statuses, m.bus, m.config, room)
+	h.Use(m.middleware...)
+	if err := h.Start(m.ctx); err != nil {
+		return nil, err
+	}
+	m.hubs[slug] = h
+	log.Printf("🚪 Room %q is open", slug)
+	return h, nil
+}
+
+func (m *RoomManager) load(slug string) (Room, error) {
+	return m.store.Rooms.Get(slug)
+}
+
+func (m *RoomManager) online(slug string) int {
+	m.mu.Lock()
+	h, ok := m.hubs[slug]
+	m.mu.Unlock()
+	if !ok {
+		return 0
+	}
+	return h.ClientCount()
+}
+
+// List returns every room with its number of connected clients.
+func (m *RoomManager) List() ([]Room, error) {
+	rooms, err := m.store.Rooms.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range rooms {
+		rooms[i].Online = m.online(rooms[i].Slug)
+	}
+	return rooms, nil
+}
+
+// Create adds a room owned by accountID.
+func (m *RoomManager) Create(slug, name string, capacity, accountID int) (Room, error) {
+	room := Room{Slug: slug, Name: name, Capacity: capacity, CreatedBy: &accountID}
+	id, err := m.store.Rooms.Create(room)
+	room.ID = id
+	return room, err
+}
+
+// Update renames a room and changes its capacity.
+func (m *RoomManager) Update(slug, name string, capacity int) error {
+	if err := m.store.Rooms.Update(slug, name, capacity); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	if h, ok := m.hubs[slug]; ok {
+		h.setCapacity(capacity)
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// Delete removes a room with its players and drawings, disconnecting
+// everyone in it.
+func (m *RoomManager) Delete(room Room) error {
+	if err := m.store.Rooms.Delete(room.ID); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	h, ok := m.hubs[room.Slug]
+	delete(m.hubs, room.Slug)
+	delete(m.emptySince, room.Slug)
+	m.mu.Unlock()
+	if ok {
+		h.Close(websocket.StatusGoingAway, "room deleted")
+	}
+	log.Printf("🗑️ Room %q deleted", room.Slug)
+	return nil
+}
+
+// Close closes every open room in parallel, saving its players, for
+// shutdown.
+func (m *RoomManager) Close() {
+	m.mu.Lock()
+	hubs := m.hubs
+	m.hubs = make(map[string]*Hub)
+	m.emptySince = make(map[string]time.Time)
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, h := range hubs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.Close(websocket.StatusGoingAway, "server shutting down")
+		}()
+	}
+	wg.Wait()
+}
+
+// WebSocketHandler joins the room named by ?room=, or the lobby. Rooms are
+// only opened for clients that are logged in.
+func (m *RoomManager) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := authenticateUpgrade(w, r, m.config.Upgrade, m.sessions)
+	if !ok {
+		return
+	}
+	slug := r.URL.Query().Get("room")
+	if slug == "" {
+		slug = DefaultRoom
+	}
+	h, err := m.Hub(slug)
+	if errors.Is(err, ErrRoomNotFound) {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Error opening room %q: %v", slug, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.serve(w, r, session)
+}
+
+// roomIDFromRequest resolves the ?room= slug of r, defaulting to the lobby.
+func roomIDFromRequest(rooms RoomStore, r *http.Request) (int, error) {
+	slug := r.URL.Query().Get("room")
+	if slug == "" {
+		slug = DefaultRoom
+	}
+	room, err := rooms.Get(slug)
+	return room.ID, err
+}
+
+type RoomRequest struct {
+	Slug     string `json:"slug"`
+	Name     string `json:"name"`
+	Capacity int    `json:"capacity"`
+}
+
+func (req *RoomRequest) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	if err := validateName(req.Name); err != nil {
+		return err
+	}
+	if req.Capacity == 0 {
+		req.Capacity = defaultRoomCapacity
+	}
+	if req.Capacity < 1 || req.Capacity > maxRoomCapacity {
+		return fmt.Errorf("capacity must be between 1 and %d", maxRoomCapacity)
+	}
+	return nil
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// RoomsHandler lists rooms on GET and creates one on POST.
+func RoomsHandler(rooms *RoomManager, sessions *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			list, err := rooms.List()
+			if err != nil {
+				writeJSONError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(list)
+
+		case http.MethodPost:
+			sess, err := sessions.FromRequest(r)
+			if err != nil {
+				writeUnauthorized(w)
+				return
+			}
+			var req RoomRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+				return
+			}
+			if !roomSlugPattern.MatchString(req.Slug) {
+				writeJSONError(w, http.StatusBadRequest, "Slug must be 2-32 lowercase letters, digits or dashes")
+				return
+			}
+			if err := req.validate(); err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			room, err := rooms.Create(req.Slug, req.Name, req.Capacity, sess.AccountID)
+			if errors.Is(err, ErrRoomExists) {
+				writeJSONError(w, http.StatusConflict, "Room already exists")
+				return
+			}
+			if err != nil {
+				writeJSONError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(room)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// RoomHandler serves /rooms/{slug}: GET shows the room, PATCH renames it or
+// changes its capacity and DELETE removes it. Only a room's creator may
+// change it.
+func RoomHandler(rooms *RoomManager, sessions *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		room, err := rooms.load(r.PathValue("slug"))
+		if errors.Is(err, ErrRoomNotFound) {
+			writeJSONError(w, http.StatusNotFound, "Room not found")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			room.Online = rooms.online(room.Slug)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(room)
+			return
+		}
+		if r.Method != http.MethodPatch && r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		sess, err := sessions.FromRequest(r)
+		if err != nil {
+			writeUnauthorized(w)
+			return
+		}
+		if room.CreatedBy == nil || *room.CreatedBy != sess.AccountID {
+			writeJSONError(w, http.StatusForbidden, "Only the room's creator can change it")
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			if err := rooms.Delete(room); err != nil {
+				log.Printf("❌ Error deleting room %q: %v", room.Slug, err)
+				writeJSONError(w, http.StatusInternalServerError, "Database error")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		req := RoomRequest{Name: room.Name, Capacity: room.Capacity}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if err := req.validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := rooms.Update(room.Slug, req.Name, req.Capacity); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		room.Name, room.Capacity = req.Name, req.Capacity
+		room.Online = rooms.online(room.Slug)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(room)
+	}
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestRoomManager(t *testing.T) *RoomManager {
+	t.Helper()
+	store := NewMemoryStore()
+	config := DefaultHubConfig()
+	config.Clock = NewFakeClock(time.Unix(1000, 0))
+	m := NewRoomManager(t.Context(), store,
+		NewSessionManager(store.Sessions, []byte("secret"), time.Hour),
+		NewDirectMessenger(store.Messages),
+		NewStatusStore(store.Accounts),
+		NewMemoryPubSub(), config)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *RoomManager) isOpen(slug string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.hubs[slug]
+	return ok
+}
+
+func TestRoomsOnlyOpenForLoggedInClients(t *testing.T) {
+	m := newTestRoomManager(t)
+	r := httptest.NewRequest(http.MethodGet, "/ws?room="+DefaultRoom, nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	w := httptest.NewRecorder()
+
+	m.WebSocketHandler(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if m.isOpen(DefaultRoom) {
+		t.Error("an anonymous request opened the room")
+	}
+}
+
+func TestEmptyRoomsClose(t *testing.T) {
+	m := newTestRoomManager(t)
+	h, err := m.Hub(DefaultRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	m.closeEmpty(start)
+	m.closeEmpty(start.Add(emptyRoomTimeout - time.Second))
+	if !m.isOpen(DefaultRoom) {
+		t.Fatal("closed before it was empty for emptyRoomTimeout")
+	}
+
+	m.closeEmpty(start.Add(emptyRoomTimeout))
+	if m.isOpen(DefaultRoom) {
+		t.Fatal("still open after being empty for emptyRoomTimeout")
+	}
+	if added, _ := h.AddClient(&Client{session: &Session{AccountID: 1}}, 0, false); added {
+		t.Error("a closed hub took a client")
+	}
+
+	reopened, err := m.Hub(DefaultRoom)
+	if err != nil || reopened == h {
+		t.Errorf("reopening gave %p, %v; the closed hub was %p", reopened, err, h)
+	}
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SDP blobs are a few KB; anything much bigger isn't a session description.
+const maxSDPLength = 16 * 1024
+
+// RTCSignalMessage is an rtc_offer, rtc_answer or rtc_ice for the player To.
+// The hub only relays it; offers and answers carry an SDP, ICE messages a
+// candidate object passed through untouched.
+type RTCSignalMessage struct {
+	To        int                    `json:"to"`
+	SDP       string                 `json:"sdp,omitempty"`
+	Candidate map[string]interface{} `json:"candidate,omitempty"`
+
+	kind string
+}
+
+func (m *RTCSignalMessage) Validate() error {
+	if err := validateID("to", m.To); err != nil {
+		return err
+	}
+	if m.kind == "rtc_ice" {
+		if len(m.Candidate) == 0 {
+			return errors.New("candidate is required")
+		}
+		return nil
+	}
+	if m.SDP == "" {
+		return errors.New("sdp is required")
+	}
+	if len(m.SDP) > maxSDPLength {
+		return fmt.Errorf("sdp must be at most %d bytes", maxSDPLength)
+	}
+	return nil
+}
+
+// RTCSignal is a relayed signaling message, as the receiving player sees it.
+type RTCSignal struct {
+	From      int                    `json:"from"`
+	SDP       string                 `json:"sdp,omitempty"`
+	Candidate map[string]interface{} `json:"candidate,omitempty"`
+}
+
+func (h *Hub) registerRTCHandlers() {
+	for _, kind := range []string{"rtc_offer", "rtc_answer", "rtc_ice"} {
+		h.Handle(kind, func() Payload { return &RTCSignalMessage{kind: kind} }, h.handleRTCSignal)
+	}
+}
+
+// handleRTCSignal relays a signaling message to whoever controls the target
+// player, as long as both players are in call range.
+func (h *Hub) handleRTCSignal(req *Request) error {
+	m := req.Payload.(*RTCSignalMessage)
+	from := req.Client.ControlledPlayer()
+	if from == 0 {
+		return &MessageError{Code: ErrCodeForbidden, Message: "control a player to make calls"}
+	}
+	if !h.peers.Near(from, m.To) {
+		return &MessageError{Code: ErrCodeForbidden, Message: fmt.Sprintf("player %d is not nearby", m.To)}
+	}
+	h.sendToPlayer(m.To, WSMessage{Type: req.Type, Data: RTCSignal{From: from, SDP: m.SDP, Candidate: m.Candidate}})
+	return nil
+}
+
+// sendToPlayer sends msg to every client controlling playerID.
+func (h *Hub) sendToPlayer(playerID int, msg WSMessage) {
+	h.sendWhere(msg, func(c *Client) bool { return c.ControlledPlayer() == playerID })
+}
+
+// controlledPlayers returns the players some client in the hub controls.
+func (h *Hub) controlledPlayers() []Player {
+	ids := make(map[int]struct{})
+	h.lock.Lock()
+	for client := range h.clients {
+		if id := client.ControlledPlayer(); id != 0 {
+			ids[id] = struct{}{}
+		}
+	}
+	h.lock.Unlock()
+
+	players := make([]Player, 0, len(ids))
+	for id := range ids {
+		if p, ok := h.world.get(id); ok {
+			players = append(players, p)
+		}
+	}
+	return players
+}
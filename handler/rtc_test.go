@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"errors"
+	"testing"
+)
+
+func rtcOffer(from *Client, to int) *Request {
+	return &Request{Type: "rtc_offer", Payload: &RTCSignalMessage{To: to, SDP: "v=0", kind: "rtc_offer"}, Client: from}
+}
+
+func wantForbidden(t *testing.T, err error) {
+	t.Helper()
+	var msgErr *MessageError
+	if !errors.As(err, &msgErr) || msgErr.Code != ErrCodeForbidden {
+		t.Fatalf("got %v, want a %s error", err, ErrCodeForbidden)
+	}
+}
+
+func TestRTCSignalsOnlyReachPlayersNearby(t *testing.T) {
+	h, _, ids := newRelayedHubs(t, "caller", "callee")
+	caller, callee := addTestClient(t, h, 1), addTestClient(t, h, 2)
+	caller.controlPlayer(ids[0])
+	callee.controlPlayer(ids[1])
+	h.world.place(PlayerPosition{ID: ids[1], X: 5000, Y: 5000})
+	h.peers.Step()
+
+	wantForbidden(t, h.handleRTCSignal(rtcOffer(caller, ids[1])))
+	if got := received(callee, "rtc_offer"); len(got) != 0 {
+		t.Fatalf("offer relayed to a player out of range: %+v", got)
+	}
+
+	h.world.place(PlayerPosition{ID: ids[1], X: 10, Y: 20})
+	h.peers.Step()
+	if err := h.handleRTCSignal(rtcOffer(caller, ids[1])); err != nil {
+		t.Fatal(err)
+	}
+	got := received(callee, "rtc_offer")
+	if len(got) != 1 || got[0].Data.(RTCSignal).From != ids[0] {
+		t.Fatalf("callee got %+v, want one offer from %d", got, ids[0])
+	}
+}
+
+func TestRTCSignalsNeedAControlledPlayer(t *testing.T) {
+	h, _, ids := newRelayedHubs(t, "callee")
+	spectator, callee := addTestClient(t, h, 1), addTestClient(t, h, 2)
+	callee.controlPlayer(ids[0])
+	h.peers.Step()
+
+	wantForbidden(t, h.handleRTCSignal(rtcOffer(spectator, ids[0])))
+	if got := received(callee, "rtc_offer"); len(got) != 0 {
+		t.Fatalf("offer relayed from a client controlling nobody: %+v", got)
+	}
+}
@@ -0,0 +1,306 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+const (
+	SessionCookieName   = "ourgatther_session"
+	ErrCodeUnauthorized = "unauthorized"
+
+	// sessionRecheckInterval bounds how long a revoked session can keep an
+	// open WebSocket alive.
+	sessionRecheckInterval = 30 * time.Second
+)
+
+var ErrInvalidSession = errors.New("invalid or expired session")
+
+type Session struct {
+	ID        string    `json:"id"`
+	AccountID int       `json:"accountId"`
+	UserAgent string    `json:"userAgent"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Current   bool      `json:"current,omitempty"`
+}
+
+// SessionManager issues and verifies signed session tokens. Tokens have the
+// form <session id>.<expiry unix>.<signature>; the signature lets forged or
+// expired tokens be rejected without a database round trip, and the session
+// row allows revocation.
+type SessionManager struct {
+	store  SessionStore
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewSessionManager(store SessionStore, secret []byte, ttl time.Duration) *SessionManager {
+	return &SessionManager{store: store, secret: secret, ttl: ttl}
+}
+
+func (s *SessionManager) sign(id string, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s.%d", id, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue creates a new session for accountID and returns its token.
+func (s *SessionManager) Issue(accountID int, userAgent string) (string, *Session, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	sess := &Session{
+		ID:        hex.EncodeToString(buf),
+		AccountID: accountID,
+		UserAgent: userAgent,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(s.ttl).Truncate(time.Second),
+	}
+
+	if err := s.store.Create(sess); err != nil {
+		return "", nil, err
+	}
+
+	expires := sess.ExpiresAt.Unix()
+	token := fmt.Sprintf("%s.%d.%s", sess.ID, expires, s.sign(sess.ID, expires))
+	return token, sess, nil
+}
+
+// Authenticate verifies token and returns its session if it is still live.
+func (s *SessionManager) Authenticate(token string) (*Session, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidSession
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(s.sign(parts[0], expires))) {
+		return nil, ErrInvalidSession
+	}
+	if time.Now().Unix() >= expires {
+		return nil, ErrInvalidSession
+	}
+	return s.store.Live(parts[0])
+}
+
+// Revoke ends one of accountID's sessions. It reports false if no such live
+// session belongs to the account.
+func (s *SessionManager) Revoke(accountID int, id string) (bool, error) {
+	return s.store.Revoke(accountID, id)
+}
+
+// List returns accountID's live sessions, newest first.
+func (s *SessionManager) List(accountID int) ([]Session, error) {
+	return s.store.List(accountID)
+}
+
+// tokenFromRequest reads a bearer token, falling back to the session cookie.
+func tokenFromRequest(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if cookie, err := r.Cookie(SessionCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// FromRequest authenticates the token carried by r.
+func (s *SessionManager) FromRequest(r *http.Request) (*Session, error) {
+	token := tokenFromRequest(r)
+	if token == "" {
+		return nil, ErrInvalidSession
+	}
+	return s.Authenticate(token)
+}
+
+func (s *SessionManager) setCookie(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+func writeUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Not logged in"})
+}
+
+// LogoutHandler revokes the caller's session and clears its cookie.
+func LogoutHandler(sessions *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		clearSessionCookie(w)
+		sess, err := sessions.FromRequest(r)
+		if err != nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if _, err := sessions.Revoke(sess.AccountID, sess.ID); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// SessionsHandler lists the caller's sessions on GET and revokes the session
+// named by ?id= on DELETE.
+func SessionsHandler(sessions *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sess, err := sessions.FromRequest(r)
+		if err != nil {
+			writeUnauthorized(w)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			list, err := sessions.List(sess.AccountID)
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+				return
+			}
+			for i := range list {
+				list[i].Current = list[i].ID == sess.ID
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(list)
+
+		case http.MethodDelete:
+			id := r.URL.Query().Get("id")
+			if id == "" {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Session ID required"})
+				return
+			}
+			ok, err := sessions.Revoke(sess.AccountID, id)
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+				return
+			}
+			if !ok {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Session not found"})
+				return
+			}
+			if id == sess.ID {
+				clearSessionCookie(w)
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// stillValid reports whether sess has neither expired nor been revoked.
+func (s *SessionManager) stillValid(sess *Session) bool {
+	if time.Now().After(sess.ExpiresAt) {
+		return false
+	}
+	if _, err := s.store.Live(sess.ID); err != nil {
+		if !errors.Is(err, ErrInvalidSession) {
+			// Don't drop players over a database hiccup.
+			log.Printf("❌ Error rechecking session for account %d: %v", sess.AccountID, err)
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// Middleware rejects messages from clients whose session has expired or been
+// revoked since the socket was opened, and closes their connection. Clients
+// that only listen are caught by the hub's heartbeat instead.
+func (s *SessionManager) Middleware() Middleware {
+	var lock sync.Mutex
+	checked := make(map[*Client]time.Time)
+
+	stillValid := func(c *Client) bool {
+		if time.Now().After(c.session.ExpiresAt) {
+			return false
+		}
+		lock.Lock()
+		last, ok := checked[c]
+		lock.Unlock()
+		if ok && time.Since(last) < sessionRecheckInterval {
+			return true
+		}
+
+		if !s.stillValid(c.session) {
+			lock.Lock()
+			delete(checked, c)
+			lock.Unlock()
+			return false
+		}
+
+		lock.Lock()
+		now := time.Now()
+		for client, at := range checked {
+			if now.Sub(at) > 2*sessionRecheckInterval {
+				delete(checked, client)
+			}
+		}
+		checked[c] = now
+		lock.Unlock()
+		return true
+	}
+
+	return func(next MessageHandler) MessageHandler {
+		return func(req *Request) error {
+			if !stillValid(req.Client) {
+				req.Client.conn.Close(websocket.StatusPolicyViolation, "session expired")
+				return &MessageError{Code: ErrCodeUnauthorized, Message: "session expired or revoked"}
+			}
+			return next(req)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+func newTestSessions(ttl time.Duration) *SessionManager {
+	return NewSessionManager(NewMemoryStore().Sessions, []byte("secret"), ttl)
+}
+
+func mustIssue(t *testing.T, sessions *SessionManager, accountID int) (string, *Session) {
+	t.Helper()
+	token, sess, err := sessions.Issue(accountID, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token, sess
+}
+
+// tamper replaces part i of a token's <id>.<expiry>.<signature>.
+func tamper(token string, i int, part string) string {
+	parts := strings.Split(token, ".")
+	parts[i] = part
+	return strings.Join(parts, ".")
+}
+
+func TestAuthenticate(t *testing.T) {
+	sessions := newTestSessions(time.Hour)
+	token, sess := mustIssue(t, sessions, 1)
+	revokedToken, revoked := mustIssue(t, sessions, 1)
+	if ok, err := sessions.Revoke(1, revoked.ID); err != nil || !ok {
+		t.Fatalf("Revoke = %v, %v", ok, err)
+	}
+	expired := newTestSessions(-time.Minute)
+	expiredToken, _ := mustIssue(t, expired, 1)
+	otherKey := NewSessionManager(sessions.store, []byte("other secret"), time.Hour)
+	forgedToken, _ := mustIssue(t, otherKey, 1)
+
+	later := strconv.FormatInt(sess.ExpiresAt.Add(24*time.Hour).Unix(), 10)
+	for _, tt := range []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"valid", token, true},
+		{"tampered signature", tamper(token, 2, "AAAA"+strings.Split(token, ".")[2][4:]), false},
+		{"tampered expiry", tamper(token, 1, later), false},
+		{"signed with another key", forgedToken, false},
+		{"expired", expiredToken, false},
+		{"revoked", revokedToken, false},
+		{"malformed", "not-a-token", false},
+		{"empty", "", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sessions.Authenticate(tt.token)
+			if !tt.valid {
+				if !errors.Is(err, ErrInvalidSession) {
+					t.Fatalf("got %+v, %v, want ErrInvalidSession", got, err)
+				}
+				return
+			}
+			if err != nil || got.ID != sess.ID || got.AccountID != 1 {
+				t.Fatalf("got %+v, %v, want session %s", got, err, sess.ID)
+			}
+		})
+	}
+}
+
+// testConn returns the server end of a WebSocket whose client end only
+// answers the close handshake, and a channel with the error that ended the
+// client's reads.
+func testConn(t *testing.T) (*websocket.Conn, <-chan error) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			close(conns)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.Dial(t.Context(), "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.CloseNow() })
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := client.Read(t.Context()); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+	conn := <-conns
+	if conn == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	return conn, closed
+}
+
+func TestSessionMiddlewareRejectsRevokedSessions(t *testing.T) {
+	sessions := newTestSessions(time.Hour)
+	_, live := mustIssue(t, sessions, 1)
+	_, revoked := mustIssue(t, sessions, 1)
+	if ok, err := sessions.Revoke(1, revoked.ID); err != nil || !ok {
+		t.Fatalf("Revoke = %v, %v", ok, err)
+	}
+
+	var handled int
+	handler := sessions.Middleware()(func(req *Request) error {
+		handled++
+		return nil
+	})
+
+	conn, _ := testConn(t)
+	if err := handler(&Request{Type: "move", Client: &Client{conn: conn, session: live}}); err != nil || handled != 1 {
+		t.Fatalf("live session: err %v, handled %d times", err, handled)
+	}
+
+	conn, closed := testConn(t)
+	err := handler(&Request{Type: "move", Client: &Client{conn: conn, session: revoked}})
+	var msgErr *MessageError
+	if !errors.As(err, &msgErr) || msgErr.Code != ErrCodeUnauthorized {
+		t.Fatalf("revoked session: got %v, want an %s error", err, ErrCodeUnauthorized)
+	}
+	if handled != 1 {
+		t.Error("the message of a revoked session was handled")
+	}
+	if err := <-closed; websocket.CloseStatus(err) != websocket.StatusPolicyViolation {
+		t.Errorf("connection of a revoked session: %v, want closed with %d", err, websocket.StatusPolicyViolation)
+	}
+}
+
+func TestSessionsHandlerOnlyRevokesOwnSessions(t *testing.T) {
+	sessions := newTestSessions(time.Hour)
+	aliceToken, _ := mustIssue(t, sessions, 1)
+	bobToken, bob := mustIssue(t, sessions, 2)
+
+	r := httptest.NewRequest(http.MethodDelete, "/sessions?id="+bob.ID, nil)
+	r.Header.Set("Authorization", "Bearer "+aliceToken)
+	w := httptest.NewRecorder()
+	SessionsHandler(sessions)(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("revoking another account's session: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if _, err := sessions.Authenticate(bobToken); err != nil {
+		t.Errorf("bob's session no longer works: %v", err)
+	}
+}
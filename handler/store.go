@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrAccountNotFound = errors.New("account not found")
+	ErrUsernameTaken   = errors.New("username already exists")
+	ErrPlayerNotFound  = errors.New("player not found")
+)
+
+// Store is everything the server keeps between restarts. Handlers only reach
+// storage through it, so the server runs the same on any implementation.
+type Store struct {
+	Accounts AccountStore
+	Players  PlayerStore
+	Drawings DrawingStore
+	Rooms    RoomStore
+	Sessions SessionStore
+	Chat     ChatStore
+	Messages MessageStore
+}
+
+type AccountStore interface {
+	// Create adds an account, failing with ErrUsernameTaken if the name is in
+	// use, and returns its ID.
+	Create(username, passwordHash string) (int, error)
+	ByUsername(username string) (Account, error)
+
+	// LastPlayer returns the player the account last controlled, or nil.
+	LastPlayer(accountID int) (*int, error)
+	SetLastPlayer(accountID int, playerID *int) error
+
+	// Status returns the account's name and the status it chose.
+	Status(accountID int) (username, status, text string, err error)
+	SetStatus(accountID int, status, text string) error
+}
+
+type PlayerStore interface {
+	// Create adds p at full health, ignoring p.ID and p.Health, and returns
+	// the new player's ID. An accountID of 0 leaves it unowned.
+	Create(p Player, accountID, roomID int) (int, error)
+	Get(id int) (Player, error)
+	List() ([]Player, error)
+	InRoom(roomID int) ([]Player, error)
+
+	// Owner returns the account owning player id, 0 if it has none yet.
+	Owner(id int) (int, error)
+	// Room returns the ID of the room player id is in.
+	Room(id int) (int, error)
+	// Claim makes accountID the owner of player id if it has no owner,
+	// reporting whether it did.
+	Claim(id, accountID int) (bool, error)
+
+	Rename(id int, name string) error
+	// ChangeHealth adds delta to the player's health, kept within 0 and
+	// ceiling, and returns the result.
+	ChangeHealth(id, delta, ceiling int) (int, error)
+	SavePositions(positions []PlayerPosition) error
+
+	// Delete removes the player with its drawings and clears it as any
+	// account's last player, all or nothing.
+	Delete(id int) error
+}
+
+type DrawingStore interface {
+	AddPoint(roomID int, p DrawingPoint) error
+	AddImage(roomID, playerID int, image string) error
+	// Points returns the room's drawn points, without saved images.
+	Points(roomID int) ([]DrawingPoint, error)
+}
+
+type RoomStore interface {
+	Get(slug string) (Room, error)
+	List() ([]Room, error)
+	// Create adds room, failing with ErrRoomExists if the slug is taken, and
+	// returns its ID.
+	Create(room Room) (int, error)
+	Update(slug, name string, capacity int) error
+	// Delete removes the room with its players, drawings and chat.
+	Delete(id int) error
+}
+
+type SessionStore interface {
+	Create(sess *Session) error
+	// Live returns session id unless it is revoked or expired, in which case
+	// it fails with ErrInvalidSession.
+	Live(id string) (*Session, error)
+	Revoke(accountID int, id string) (bool, error)
+	// List returns the account's live sessions, newest first.
+	List(accountID int) ([]Session, error)
+}
+
+type ChatStore interface {
+	// Add saves msg, said by accountID in roomID, setting its ID and time.
+	Add(roomID, accountID int, msg *ChatMessage) error
+	// Recent returns the room's last limit messages, newest first.
+	Recent(roomID, limit int) ([]ChatMessage, error)
+}
+
+type MessageStore interface {
+	// Send saves msg, setting its ID and time, or fails with
+	// ErrAccountNotFound if the recipient doesn't exist.
+	Send(msg *DirectMessage) error
+	// MarkRead marks reader's unread messages from sender up to upTo as
+	// read. It returns the last one marked, or 0 if there were none.
+	MarkRead(reader, sender int, upTo int64) (last int64, readAt time.Time, err error)
+	Unread(accountID int) ([]DMUnread, error)
+	History(a, b int, before int64, limit int) ([]DirectMessage, error)
+}
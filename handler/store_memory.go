@@ -0,0 +1,505 @@
+package handler
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// NewMemoryStore keeps everything in memory, for running and testing the
+// server without a database. Nothing survives a restart. It starts with the
+// lobby, like a freshly migrated database.
+func NewMemoryStore() *Store {
+	m := &memoryDB{
+		accounts: make(map[int]*memAccount),
+		players:  make(map[int]*memPlayer),
+		rooms:    make(map[int]*Room),
+		sessions: make(map[string]*memSession),
+	}
+	m.nextRoom++
+	m.rooms[m.nextRoom] = &Room{ID: m.nextRoom, Slug: DefaultRoom, Name: "Lobby", Capacity: defaultRoomCapacity}
+	return &Store{
+		Accounts: memAccounts{m},
+		Players:  memPlayers{m},
+		Drawings: memDrawings{m},
+		Rooms:    memRooms{m},
+		Sessions: memSessions{m},
+		Chat:     memChat{m},
+		Messages: memMessages{m},
+	}
+}
+
+// memoryDB is the data behind a memory store; one lock covers all of it, so
+// every operation is atomic.
+type memoryDB struct {
+	mu sync.Mutex
+
+	accounts map[int]*memAccount
+	players  map[int]*memPlayer
+	drawings []memDrawing
+	rooms    map[int]*Room
+	sessions map[string]*memSession
+	chat     []memChatMessage
+	dms      []DirectMessage // in ID order
+
+	nextAccount, nextPlayer, nextRoom int
+	nextChat, nextDM                  int64
+}
+
+type memAccount struct {
+	Account
+	status, statusText string
+}
+
+type memPlayer struct {
+	Player
+	accountID, roomID int
+}
+
+type memDrawing struct {
+	DrawingPoint
+	roomID int
+	image  string
+}
+
+type memSession struct {
+	Session
+	revoked bool
+}
+
+type memChatMessage struct {
+	ChatMessage
+	roomID int
+}
+
+type memAccounts struct{ *memoryDB }
+
+func (s memAccounts) Create(username, passwordHash string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range s.accounts {
+		if a.Username == username {
+			return 0, ErrUsernameTaken
+		}
+	}
+	s.nextAccount++
+	s.accounts[s.nextAccount] = &memAccount{
+		Account: Account{ID: s.nextAccount, Username: username, PasswordHash: passwordHash},
+		status:  StatusOnline,
+	}
+	return s.nextAccount, nil
+}
+
+func (s memAccounts) ByUsername(username string) (Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range s.accounts {
+		if a.Username == username {
+			return a.Account, nil
+		}
+	}
+	return Account{}, ErrAccountNotFound
+}
+
+func (s memAccounts) LastPlayer(accountID int) (*int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.accounts[accountID]
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+	return a.LastPlayerID, nil
+}
+
+func (s memAccounts) SetLastPlayer(accountID int, playerID *int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a, ok := s.accounts[accountID]; ok {
+		a.LastPlayerID = playerID
+	}
+	return nil
+}
+
+func (s memAccounts) Status(accountID int) (username, status, text string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.accounts[accountID]
+	if !ok {
+		return "", "", "", ErrAccountNotFound
+	}
+	return a.Username, a.status, a.statusText, nil
+}
+
+func (s memAccounts) SetStatus(accountID int, status, text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a, ok := s.accounts[accountID]; ok {
+		a.status, a.statusText = status, text
+	}
+	return nil
+}
+
+type memPlayers struct{ *memoryDB }
+
+func (s memPlayers) Create(p Player, accountID, roomID int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextPlayer++
+	p.ID = s.nextPlayer
+	p.Health = maxHealth
+	s.players[p.ID] = &memPlayer{Player: p, accountID: accountID, roomID: roomID}
+	return p.ID, nil
+}
+
+func (s memPlayers) Get(id int) (Player, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.players[id]
+	if !ok {
+		return Player{}, ErrPlayerNotFound
+	}
+	return p.Player, nil
+}
+
+func (s memPlayers) List() ([]Player, error) {
+	return s.where(func(*memPlayer) bool { return true }), nil
+}
+
+func (s memPlayers) InRoom(roomID int) ([]Player, error) {
+	return s.where(func(p *memPlayer) bool { return p.roomID == roomID }), nil
+}
+
+func (s memPlayers) where(include func(*memPlayer) bool) []Player {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var players []Player
+	for _, p := range s.players {
+		if include(p) {
+			players = append(players, p.Player)
+		}
+	}
+	sort.Slice(players, func(i, j int) bool { return players[i].ID < players[j].ID })
+	return players
+}
+
+func (s memPlayers) Owner(id int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.players[id]
+	if !ok {
+		return 0, ErrPlayerNotFound
+	}
+	return p.accountID, nil
+}
+
+func (s memPlayers) Room(id int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.players[id]
+	if !ok {
+		return 0, ErrPlayerNotFound
+	}
+	return p.roomID, nil
+}
+
+func (s memPlayers) Claim(id, accountID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.players[id]
+	if !ok || p.accountID != 0 {
+		return false, nil
+	}
+	p.accountID = accountID
+	return true, nil
+}
+
+func (s memPlayers) Rename(id int, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.players[id]; ok {
+		p.Name = name
+	}
+	return nil
+}
+
+func (s memPlayers) ChangeHealth(id, delta, ceiling int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.players[id]
+	if !ok {
+		return 0, ErrPlayerNotFound
+	}
+	p.Health = min(ceiling, max(0, p.Health+delta))
+	return p.Health, nil
+}
+
+func (s memPlayers) SavePositions(positions []PlayerPosition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, pos := range positions {
+		if p, ok := s.players[pos.ID]; ok {
+			p.X, p.Y = pos.X, pos.Y
+		}
+	}
+	return nil
+}
+
+func (s memPlayers) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deletePlayers(func(p *memPlayer) bool { return p.ID == id })
+	return nil
+}
+
+// deletePlayers removes the players include selects, with their drawings and
+// last-player references. Callers hold s.mu.
+func (m *memoryDB) deletePlayers(include func(*memPlayer) bool) {
+	for id, p := range m.players {
+		if !include(p) {
+			continue
+		}
+		drawings := m.drawings[:0]
+		for _, d := range m.drawings {
+			if d.PlayerID != id {
+				drawings = append(drawings, d)
+			}
+		}
+		m.drawings = drawings
+		for _, a := range m.accounts {
+			if a.LastPlayerID != nil && *a.LastPlayerID == id {
+				a.LastPlayerID = nil
+			}
+		}
+		delete(m.players, id)
+	}
+}
+
+type memDrawings struct{ *memoryDB }
+
+func (s memDrawings) AddPoint(roomID int, p DrawingPoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drawings = append(s.drawings, memDrawing{DrawingPoint: p, roomID: roomID})
+	return nil
+}
+
+func (s memDrawings) AddImage(roomID, playerID int, image string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drawings = append(s.drawings, memDrawing{DrawingPoint: DrawingPoint{PlayerID: playerID}, roomID: roomID, image: image})
+	return nil
+}
+
+func (s memDrawings) Points(roomID int) ([]DrawingPoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var points []DrawingPoint
+	for _, d := range s.drawings {
+		if d.roomID == roomID && d.image == "" {
+			points = append(points, DrawingPoint{X: d.X, Y: d.Y, Color: d.Color, Size: d.Size})
+		}
+	}
+	return points, nil
+}
+
+type memRooms struct{ *memoryDB }
+
+func (s memRooms) Get(slug string) (Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, room := range s.rooms {
+		if room.Slug == slug {
+			return *room, nil
+		}
+	}
+	return Room{}, ErrRoomNotFound
+}
+
+func (s memRooms) List() ([]Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rooms := make([]Room, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		rooms = append(rooms, *room)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].ID < rooms[j].ID })
+	return rooms, nil
+}
+
+func (s memRooms) Create(room Room) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.rooms {
+		if r.Slug == room.Slug {
+			return 0, ErrRoomExists
+		}
+	}
+	s.nextRoom++
+	room.ID = s.nextRoom
+	s.rooms[room.ID] = &room
+	return room.ID, nil
+}
+
+func (s memRooms) Update(slug, name string, capacity int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, room := range s.rooms {
+		if room.Slug == slug {
+			room.Name, room.Capacity = name, capacity
+			return nil
+		}
+	}
+	return ErrRoomNotFound
+}
+
+func (s memRooms) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chat := s.chat[:0]
+	for _, msg := range s.chat {
+		if msg.roomID != id {
+			chat = append(chat, msg)
+		}
+	}
+	s.chat = chat
+	drawings := s.drawings[:0]
+	for _, d := range s.drawings {
+		if d.roomID != id {
+			drawings = append(drawings, d)
+		}
+	}
+	s.drawings = drawings
+	s.deletePlayers(func(p *memPlayer) bool { return p.roomID == id })
+	delete(s.rooms, id)
+	return nil
+}
+
+type memSessions struct{ *memoryDB }
+
+func (s memSessions) Create(sess *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[sess.ID] = &memSession{Session: *sess}
+	return nil
+}
+
+func (s memSessions) Live(id string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok || sess.revoked || !sess.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidSession
+	}
+	live := sess.Session
+	return &live, nil
+}
+
+func (s memSessions) Revoke(accountID int, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok || sess.AccountID != accountID || sess.revoked {
+		return false, nil
+	}
+	sess.revoked = true
+	return true, nil
+}
+
+func (s memSessions) List(accountID int) ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := []Session{}
+	now := time.Now()
+	for _, sess := range s.sessions {
+		if sess.AccountID == accountID && !sess.revoked && sess.ExpiresAt.After(now) {
+			sessions = append(sessions, sess.Session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.After(sessions[j].CreatedAt) })
+	return sessions, nil
+}
+
+type memChat struct{ *memoryDB }
+
+func (s memChat) Add(roomID, accountID int, msg *ChatMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextChat++
+	msg.ID = s.nextChat
+	msg.CreatedAt = time.Now()
+	s.chat = append(s.chat, memChatMessage{ChatMessage: *msg, roomID: roomID})
+	return nil
+}
+
+func (s memChat) Recent(roomID, limit int) ([]ChatMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []ChatMessage
+	for i := len(s.chat) - 1; i >= 0 && len(messages) < limit; i-- {
+		if s.chat[i].roomID == roomID {
+			messages = append(messages, s.chat[i].ChatMessage)
+		}
+	}
+	return messages, nil
+}
+
+type memMessages struct{ *memoryDB }
+
+func (s memMessages) Send(msg *DirectMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.accounts[msg.RecipientID]; !ok {
+		return ErrAccountNotFound
+	}
+	s.nextDM++
+	msg.ID = s.nextDM
+	msg.CreatedAt = time.Now()
+	s.dms = append(s.dms, *msg)
+	return nil
+}
+
+func (s memMessages) MarkRead(reader, sender int, upTo int64) (int64, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var last int64
+	for i := range s.dms {
+		m := &s.dms[i]
+		if m.RecipientID == reader && m.SenderID == sender && m.ID <= upTo && m.ReadAt == nil {
+			m.ReadAt = &now
+			last = m.ID
+		}
+	}
+	return last, now, nil
+}
+
+func (s memMessages) Unread(accountID int) ([]DMUnread, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[int]int)
+	for _, m := range s.dms {
+		if m.RecipientID == accountID && m.ReadAt == nil {
+			counts[m.SenderID]++
+		}
+	}
+	unread := []DMUnread{}
+	for from, count := range counts {
+		unread = append(unread, DMUnread{From: from, Count: count})
+	}
+	sort.Slice(unread, func(i, j int) bool { return unread[i].From < unread[j].From })
+	return unread, nil
+}
+
+func (s memMessages) History(a, b int, before int64, limit int) ([]DirectMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	messages := []DirectMessage{}
+	for i := len(s.dms) - 1; i >= 0 && len(messages) < limit; i-- {
+		m := s.dms[i]
+		between := (m.SenderID == a && m.RecipientID == b) || (m.SenderID == b && m.RecipientID == a)
+		if between && m.ID < before {
+			messages = append(messages, m)
+		}
+	}
+	return messages, nil
+}
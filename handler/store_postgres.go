@@ -0,0 +1,446 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// NewPostgresStore keeps everything in the Postgres database db, whose schema
+// the migrate package manages.
+func NewPostgresStore(db *sql.DB) *Store {
+	return &Store{
+		Accounts: pgAccounts{db},
+		Players:  pgPlayers{db},
+		Drawings: pgDrawings{db},
+		Rooms:    pgRooms{db},
+		Sessions: pgSessions{db},
+		Chat:     pgChat{db},
+		Messages: pgMessages{db},
+	}
+}
+
+type pgAccounts struct{ db *sql.DB }
+
+func (s pgAccounts) Create(username, passwordHash string) (int, error) {
+	var id int
+	err := s.db.QueryRow("INSERT INTO account (username, password_hash) VALUES ($1, $2) RETURNING id",
+		username, passwordHash).Scan(&id)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" { // unique_violation
+		return 0, ErrUsernameTaken
+	}
+	return id, err
+}
+
+func (s pgAccounts) ByUsername(username string) (Account, error) {
+	var account Account
+	err := s.db.QueryRow("SELECT id, username, password_hash, last_player_id FROM account WHERE username = $1",
+		username).Scan(&account.ID, &account.Username, &account.PasswordHash, &account.LastPlayerID)
+	if err == sql.ErrNoRows {
+		return account, ErrAccountNotFound
+	}
+	return account, err
+}
+
+func (s pgAccounts) LastPlayer(accountID int) (*int, error) {
+	var lastPlayerID *int
+	err := s.db.QueryRow("SELECT last_player_id FROM account WHERE id = $1", accountID).Scan(&lastPlayerID)
+	if err == sql.ErrNoRows {
+		return nil, ErrAccountNotFound
+	}
+	return lastPlayerID, err
+}
+
+func (s pgAccounts) SetLastPlayer(accountID int, playerID *int) error {
+	_, err := s.db.Exec("UPDATE account SET last_player_id = $1 WHERE id = $2", playerID, accountID)
+	return err
+}
+
+func (s pgAccounts) Status(accountID int) (username, status, text string, err error) {
+	err = s.db.QueryRow("SELECT username, status, status_text FROM account WHERE id = $1", accountID).Scan(&username, &status, &text)
+	if err == sql.ErrNoRows {
+		err = ErrAccountNotFound
+	}
+	return username, status, text, err
+}
+
+func (s pgAccounts) SetStatus(accountID int, status, text string) error {
+	_, err := s.db.Exec("UPDATE account SET status = $1, status_text = $2 WHERE id = $3", status, text, accountID)
+	return err
+}
+
+type pgPlayers struct{ db *sql.DB }
+
+func (s pgPlayers) Create(p Player, accountID, roomID int) (int, error) {
+	var id int
+	err := s.db.QueryRow("INSERT INTO player (name, x, y, color, account_id, room_id) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		p.Name, p.X, p.Y, p.Color, sql.NullInt64{Int64: int64(accountID), Valid: accountID != 0}, roomID).Scan(&id)
+	return id, err
+}
+
+func (s pgPlayers) Get(id int) (Player, error) {
+	var p Player
+	err := s.db.QueryRow("SELECT id, name, x, y, color, health FROM player WHERE id = $1", id).Scan(
+		&p.ID, &p.Name, &p.X, &p.Y, &p.Color, &p.Health)
+	if err == sql.ErrNoRows {
+		return p, ErrPlayerNotFound
+	}
+	return p, err
+}
+
+func (s pgPlayers) List() ([]Player, error) {
+	return s.query("SELECT id, name, x, y, color, health FROM player ORDER BY id")
+}
+
+func (s pgPlayers) InRoom(roomID int) ([]Player, error) {
+	return s.query("SELECT id, name, x, y, color, health FROM player WHERE room_id = $1 ORDER BY id", roomID)
+}
+
+func (s pgPlayers) query(query string, args ...any) ([]Player, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var players []Player
+	for rows.Next() {
+		var p Player
+		if err := rows.Scan(&p.ID, &p.Name, &p.X, &p.Y, &p.Color, &p.Health); err != nil {
+			return nil, err
+		}
+		players = append(players, p)
+	}
+	return players, rows.Err()
+}
+
+func (s pgPlayers) Owner(id int) (int, error) {
+	var accountID sql.NullInt64
+	err := s.db.QueryRow("SELECT account_id FROM player WHERE id = $1", id).Scan(&accountID)
+	if err == sql.ErrNoRows {
+		return 0, ErrPlayerNotFound
+	}
+	return int(accountID.Int64), err
+}
+
+func (s pgPlayers) Room(id int) (int, error) {
+	var roomID sql.NullInt64
+	err := s.db.QueryRow("SELECT room_id FROM player WHERE id = $1", id).Scan(&roomID)
+	if err == sql.ErrNoRows {
+		return 0, ErrPlayerNotFound
+	}
+	return int(roomID.Int64), err
+}
+
+func (s pgPlayers) Claim(id, accountID int) (bool, error) {
+	res, err := s.db.Exec("UPDATE player SET account_id = $1 WHERE id = $2 AND account_id IS NULL", accountID, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s pgPlayers) Rename(id int, name string) error {
+	_, err := s.db.Exec("UPDATE player SET name = $1 WHERE id = $2", name, id)
+	return err
+}
+
+func (s pgPlayers) ChangeHealth(id, delta, ceiling int) (int, error) {
+	var health int
+	err := s.db.QueryRow("UPDATE player SET health = LEAST($1, GREATEST(0, health + $2)) WHERE id = $3 RETURNING health",
+		ceiling, delta, id).Scan(&health)
+	if err == sql.ErrNoRows {
+		return 0, ErrPlayerNotFound
+	}
+	return health, err
+}
+
+func (s pgPlayers) SavePositions(positions []PlayerPosition) error {
+	ids := make([]int64, len(positions))
+	xs := make([]int64, len(positions))
+	ys := make([]int64, len(positions))
+	for i, pos := range positions {
+		ids[i], xs[i], ys[i] = int64(pos.ID), int64(pos.X), int64(pos.Y)
+	}
+	_, err := s.db.Exec(`UPDATE player SET x = moved.x, y = moved.y
+		FROM unnest($1::int[], $2::int[], $3::int[]) AS moved(id, x, y)
+		WHERE player.id = moved.id`, pq.Array(ids), pq.Array(xs), pq.Array(ys))
+	return err
+}
+
+func (s pgPlayers) Delete(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range []string{
+		"DELETE FROM drawing WHERE player_id = $1",
+		"UPDATE account SET last_player_id = NULL WHERE last_player_id = $1",
+		"DELETE FROM player WHERE id = $1",
+	} {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+type pgDrawings struct{ db *sql.DB }
+
+func (s pgDrawings) AddPoint(roomID int, p DrawingPoint) error {
+	_, err := s.db.Exec(`
+		INSERT INTO drawing (player_id, x, y, color, size, image, room_id)
+		VALUES ($1, $2, $3, $4, $5, '', $6)`, p.PlayerID, p.X, p.Y, p.Color, p.Size, roomID)
+	return err
+}
+
+func (s pgDrawings) AddImage(roomID, playerID int, image string) error {
+	_, err := s.db.Exec("INSERT INTO drawing (player_id, x, y, image, room_id) VALUES ($1, 0, 0, $2, $3)", playerID, image, roomID)
+	return err
+}
+
+func (s pgDrawings) Points(roomID int) ([]DrawingPoint, error) {
+	rows, err := s.db.Query("SELECT x, y, color, size FROM drawing WHERE room_id = $1 AND image = ''", roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []DrawingPoint
+	for rows.Next() {
+		var p DrawingPoint
+		if err := rows.Scan(&p.X, &p.Y, &p.Color, &p.Size); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+type pgRooms struct{ db *sql.DB }
+
+func (s pgRooms) Get(slug string) (Room, error) {
+	var room Room
+	err := s.db.QueryRow("SELECT id, slug, name, capacity, created_by FROM room WHERE slug = $1", slug).Scan(
+		&room.ID, &room.Slug, &room.Name, &room.Capacity, &room.CreatedBy)
+	if err == sql.ErrNoRows {
+		return room, ErrRoomNotFound
+	}
+	return room, err
+}
+
+func (s pgRooms) List() ([]Room, error) {
+	rows, err := s.db.Query("SELECT id, slug, name, capacity, created_by FROM room ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := []Room{}
+	for rows.Next() {
+		var room Room
+		if err := rows.Scan(&room.ID, &room.Slug, &room.Name, &room.Capacity, &room.CreatedBy); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, rows.Err()
+}
+
+func (s pgRooms) Create(room Room) (int, error) {
+	var id int
+	err := s.db.QueryRow(`
+		INSERT INTO room (slug, name, capacity, created_by) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (slug) DO NOTHING RETURNING id`, room.Slug, room.Name, room.Capacity, room.CreatedBy).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrRoomExists
+	}
+	return id, err
+}
+
+func (s pgRooms) Update(slug, name string, capacity int) error {
+	res, err := s.db.Exec("UPDATE room SET name = $1, capacity = $2 WHERE slug = $3", name, capacity, slug)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrRoomNotFound
+	}
+	return nil
+}
+
+func (s pgRooms) Delete(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range []string{
+		"DELETE FROM chat_message WHERE room_id = $1",
+		"DELETE FROM drawing WHERE room_id = $1 OR player_id IN (SELECT id FROM player WHERE room_id = $1)",
+		"UPDATE account SET last_player_id = NULL WHERE last_player_id IN (SELECT id FROM player WHERE room_id = $1)",
+		"DELETE FROM player WHERE room_id = $1",
+		"DELETE FROM room WHERE id = $1",
+	} {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+type pgSessions struct{ db *sql.DB }
+
+func (s pgSessions) Create(sess *Session) error {
+	_, err := s.db.Exec("INSERT INTO session (id, account_id, user_agent, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		sess.ID, sess.AccountID, sess.UserAgent, sess.CreatedAt, sess.ExpiresAt)
+	return err
+}
+
+func (s pgSessions) Live(id string) (*Session, error) {
+	var sess Session
+	err := s.db.QueryRow(`
+		SELECT id, account_id, user_agent, created_at, expires_at FROM session
+		WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW()`, id).Scan(
+		&sess.ID, &sess.AccountID, &sess.UserAgent, &sess.CreatedAt, &sess.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+func (s pgSessions) Revoke(accountID int, id string) (bool, error) {
+	res, err := s.db.Exec("UPDATE session SET revoked_at = NOW() WHERE id = $1 AND account_id = $2 AND revoked_at IS NULL",
+		id, accountID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s pgSessions) List(accountID int) ([]Session, error) {
+	rows, err := s.db.Query(`
+		SELECT id, account_id, user_agent, created_at, expires_at FROM session
+		WHERE account_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY created_at DESC`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var sess Session
+		if err := rows.Scan(&sess.ID, &sess.AccountID, &sess.UserAgent, &sess.CreatedAt, &sess.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+type pgChat struct{ db *sql.DB }
+
+func (s pgChat) Add(roomID, accountID int, msg *ChatMessage) error {
+	return s.db.QueryRow(`
+		INSERT INTO chat_message (room_id, account_id, player_id, name, text, shout, x, y)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at`,
+		roomID, accountID, msg.PlayerID, msg.Name, msg.Text, msg.Shout, msg.x, msg.y).Scan(&msg.ID, &msg.CreatedAt)
+}
+
+func (s pgChat) Recent(roomID, limit int) ([]ChatMessage, error) {
+	rows, err := s.db.Query(`
+		SELECT id, player_id, name, text, shout, x, y, created_at FROM chat_message
+		WHERE room_id = $1 ORDER BY id DESC LIMIT $2`, roomID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []ChatMessage
+	for rows.Next() {
+		var msg ChatMessage
+		if err := rows.Scan(&msg.ID, &msg.PlayerID, &msg.Name, &msg.Text, &msg.Shout, &msg.x, &msg.y, &msg.CreatedAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+type pgMessages struct{ db *sql.DB }
+
+func (s pgMessages) Send(msg *DirectMessage) error {
+	err := s.db.QueryRow(`
+		INSERT INTO direct_message (sender_id, recipient_id, text)
+		SELECT $1, id, $3 FROM account WHERE id = $2
+		RETURNING id, created_at`, msg.SenderID, msg.RecipientID, msg.Text).Scan(&msg.ID, &msg.CreatedAt)
+	if err == sql.ErrNoRows {
+		return ErrAccountNotFound
+	}
+	return err
+}
+
+func (s pgMessages) MarkRead(reader, sender int, upTo int64) (int64, time.Time, error) {
+	var readAt time.Time
+	var last sql.NullInt64
+	err := s.db.QueryRow(`
+		WITH updated AS (
+			UPDATE direct_message SET read_at = NOW()
+			WHERE recipient_id = $1 AND sender_id = $2 AND id <= $3 AND read_at IS NULL
+			RETURNING id, read_at
+		)
+		SELECT MAX(id), COALESCE(MAX(read_at), NOW()) FROM updated`, reader, sender, upTo).Scan(&last, &readAt)
+	return last.Int64, readAt, err
+}
+
+func (s pgMessages) Unread(accountID int) ([]DMUnread, error) {
+	rows, err := s.db.Query(`
+		SELECT sender_id, COUNT(*) FROM direct_message
+		WHERE recipient_id = $1 AND read_at IS NULL
+		GROUP BY sender_id ORDER BY sender_id`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	unread := []DMUnread{}
+	for rows.Next() {
+		var u DMUnread
+		if err := rows.Scan(&u.From, &u.Count); err != nil {
+			return nil, err
+		}
+		unread = append(unread, u)
+	}
+	return unread, rows.Err()
+}
+
+func (s pgMessages) History(a, b int, before int64, limit int) ([]DirectMessage, error) {
+	rows, err := s.db.Query(`
+		SELECT id, sender_id, recipient_id, text, created_at, read_at FROM direct_message
+		WHERE ((sender_id = $1 AND recipient_id = $2) OR (sender_id = $2 AND recipient_id = $1)) AND id < $3
+		ORDER BY id DESC LIMIT $4`, a, b, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []DirectMessage{}
+	for rows.Next() {
+		var m DirectMessage
+		if err := rows.Scan(&m.ID, &m.SenderID, &m.RecipientID, &m.Text, &m.CreatedAt, &m.ReadAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// OpenSQLite opens the SQLite database file at path, which may carry its own
+// ?query options, with foreign keys enforced and times written in a format
+// SQLite's date functions understand.
+func OpenSQLite(path string) (*sql.DB, error) {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	db, err := sql.Open("sqlite", "file:"+path+sep+
+		"_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate&_time_format=sqlite")
+	if err != nil {
+		return nil, err
+	}
+	// One connection serializes writes, which SQLite does anyway, and keeps
+	// ":memory:" databases from being one per connection.
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// NewSQLiteStore keeps everything in the SQLite database db, whose schema the
+// migrate package manages. Queries that read the same in both dialects come
+// from the Postgres store; SQLite numbers $N parameters in the order they
+// appear, so those must use them in order.
+//
+// SQLite has no clock of its own worth comparing against, so times are
+// written from Go in UTC, which keeps them ordered as text.
+func NewSQLiteStore(db *sql.DB) *Store {
+	return &Store{
+		Accounts: sqliteAccounts{pgAccounts{db}},
+		Players:  sqlitePlayers{pgPlayers{db}},
+		Drawings: pgDrawings{db},
+		Rooms:    pgRooms{db},
+		Sessions: sqliteSessions{db},
+		Chat:     sqliteChat{pgChat{db}},
+		Messages: sqliteMessages{pgMessages{db}},
+	}
+}
+
+type sqliteAccounts struct{ pgAccounts }
+
+func (s sqliteAccounts) Create(username, passwordHash string) (int, error) {
+	var id int
+	err := s.db.QueryRow("INSERT INTO account (username, password_hash) VALUES (?, ?) RETURNING id",
+		username, passwordHash).Scan(&id)
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+		return 0, ErrUsernameTaken
+	}
+	return id, err
+}
+
+type sqlitePlayers struct{ pgPlayers }
+
+func (s sqlitePlayers) ChangeHealth(id, delta, ceiling int) (int, error) {
+	var health int
+	err := s.db.QueryRow("UPDATE player SET health = MIN(?, MAX(0, health + ?)) WHERE id = ? RETURNING health",
+		ceiling, delta, id).Scan(&health)
+	if err == sql.ErrNoRows {
+		return 0, ErrPlayerNotFound
+	}
+	return health, err
+}
+
+func (s sqlitePlayers) SavePositions(positions []PlayerPosition) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("UPDATE player SET x = ?, y = ? WHERE id = ?")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, pos := range positions {
+		if _, err := stmt.Exec(pos.X, pos.Y, pos.ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+type sqliteSessions struct{ db *sql.DB }
+
+func (s sqliteSessions) Create(sess *Session) error {
+	_, err := s.db.Exec("INSERT INTO session (id, account_id, user_agent, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		sess.ID, sess.AccountID, sess.UserAgent, sess.CreatedAt.UTC(), sess.ExpiresAt.UTC())
+	return err
+}
+
+func (s sqliteSessions) Live(id string) (*Session, error) {
+	var sess Session
+	err := s.db.QueryRow(`
+		SELECT id, account_id, user_agent, created_at, expires_at FROM session
+		WHERE id = ? AND revoked_at IS NULL AND expires_at > ?`, id, time.Now().UTC()).Scan(
+		&sess.ID, &sess.AccountID, &sess.UserAgent, &sess.CreatedAt, &sess.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+func (s sqliteSessions) Revoke(accountID int, id string) (bool, error) {
+	res, err := s.db.Exec("UPDATE session SET revoked_at = ? WHERE id = ? AND account_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), id, accountID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s sqliteSessions) List(accountID int) ([]Session, error) {
+	rows, err := s.db.Query(`
+		SELECT id, account_id, user_agent, created_at, expires_at FROM session
+		WHERE account_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY created_at DESC`, accountID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var sess Session
+		if err := rows.Scan(&sess.ID, &sess.AccountID, &sess.UserAgent, &sess.CreatedAt, &sess.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+type sqliteChat struct{ pgChat }
+
+func (s sqliteChat) Add(roomID, accountID int, msg *ChatMessage) error {
+	now := time.Now().UTC()
+	err := s.db.QueryRow(`
+		INSERT INTO chat_message (room_id, account_id, player_id, name, text, shout, x, y, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		roomID, accountID, msg.PlayerID, msg.Name, msg.Text, msg.Shout, msg.x, msg.y, now).Scan(&msg.ID)
+	if err != nil {
+		return err
+	}
+	msg.CreatedAt = now
+	return nil
+}
+
+type sqliteMessages struct{ pgMessages }
+
+func (s sqliteMessages) Send(msg *DirectMessage) error {
+	now := time.Now().UTC()
+	err := s.db.QueryRow(`
+		INSERT INTO direct_message (sender_id, recipient_id, text, created_at)
+		SELECT ?, id, ?, ? FROM account WHERE id = ?
+		RETURNING id`, msg.SenderID, msg.Text, now, msg.RecipientID).Scan(&msg.ID)
+	if err == sql.ErrNoRows {
+		return ErrAccountNotFound
+	}
+	if err != nil {
+		return err
+	}
+	msg.CreatedAt = now
+	return nil
+}
+
+func (s sqliteMessages) MarkRead(reader, sender int, upTo int64) (int64, time.Time, error) {
+	now := time.Now().UTC()
+	rows, err := s.db.Query(`
+		UPDATE direct_message SET read_at = ?
+		WHERE recipient_id = ? AND sender_id = ? AND id <= ? AND read_at IS NULL
+		RETURNING id`, now, reader, sender, upTo)
+	if err != nil {
+		return 0, now, err
+	}
+	defer rows.Close()
+
+	var last int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return 0, now, err
+		}
+		last = max(last, id)
+	}
+	return last, now, rows.Err()
+}
@@ -0,0 +1,495 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ourgatther/migrate"
+)
+
+// forEachStore runs test against every store implementation, each starting
+// empty apart from the lobby, so they are held to the same behaviour.
+func forEachStore(t *testing.T, test func(t *testing.T, s *Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		test(t, newSQLiteTestStore(t))
+	})
+}
+
+func newSQLiteTestStore(t *testing.T) *Store {
+	t.Helper()
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrator, err := migrate.New(db, migrate.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return NewSQLiteStore(db)
+}
+
+func mustAccount(t *testing.T, s *Store, username string) int {
+	t.Helper()
+	id, err := s.Accounts.Create(username, "hash")
+	if err != nil {
+		t.Fatalf("creating account %q: %v", username, err)
+	}
+	return id
+}
+
+func mustLobby(t *testing.T, s *Store) Room {
+	t.Helper()
+	lobby, err := s.Rooms.Get(DefaultRoom)
+	if err != nil {
+		t.Fatalf("getting the lobby: %v", err)
+	}
+	return lobby
+}
+
+func mustPlayer(t *testing.T, s *Store, name string, accountID, roomID int) int {
+	t.Helper()
+	id, err := s.Players.Create(Player{Name: name, X: 10, Y: 20, Color: "teal", Health: maxHealth}, accountID, roomID)
+	if err != nil {
+		t.Fatalf("creating player %q: %v", name, err)
+	}
+	return id
+}
+
+func TestStoreAccounts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *Store) {
+		id := mustAccount(t, s, "alice")
+		if _, err := s.Accounts.Create("alice", "other"); !errors.Is(err, ErrUsernameTaken) {
+			t.Fatalf("duplicate username: got %v, want ErrUsernameTaken", err)
+		}
+
+		account, err := s.Accounts.ByUsername("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if account.ID != id || account.PasswordHash != "hash" || account.LastPlayerID != nil {
+			t.Errorf("ByUsername = %+v", account)
+		}
+		if _, err := s.Accounts.ByUsername("nobody"); !errors.Is(err, ErrAccountNotFound) {
+			t.Errorf("unknown username: got %v, want ErrAccountNotFound", err)
+		}
+
+		username, status, text, err := s.Accounts.Status(id)
+		if err != nil || username != "alice" || status != StatusOnline || text != "" {
+			t.Errorf("Status = %q, %q, %q, %v", username, status, text, err)
+		}
+		if err := s.Accounts.SetStatus(id, StatusBusy, "in a meeting"); err != nil {
+			t.Fatal(err)
+		}
+		if _, status, text, _ = s.Accounts.Status(id); status != StatusBusy || text != "in a meeting" {
+			t.Errorf("after SetStatus: %q, %q", status, text)
+		}
+		if _, _, _, err := s.Accounts.Status(999); !errors.Is(err, ErrAccountNotFound) {
+			t.Errorf("unknown account status: got %v, want ErrAccountNotFound", err)
+		}
+
+		playerID := mustPlayer(t, s, "Al", id, mustLobby(t, s).ID)
+		if err := s.Accounts.SetLastPlayer(id, &playerID); err != nil {
+			t.Fatal(err)
+		}
+		if last, err := s.Accounts.LastPlayer(id); err != nil || last == nil || *last != playerID {
+			t.Errorf("LastPlayer = %v, %v, want %d", last, err, playerID)
+		}
+		if err := s.Accounts.SetLastPlayer(id, nil); err != nil {
+			t.Fatal(err)
+		}
+		if last, err := s.Accounts.LastPlayer(id); err != nil || last != nil {
+			t.Errorf("LastPlayer after clearing = %v, %v", last, err)
+		}
+		if _, err := s.Accounts.LastPlayer(999); !errors.Is(err, ErrAccountNotFound) {
+			t.Errorf("unknown account last player: got %v, want ErrAccountNotFound", err)
+		}
+	})
+}
+
+func TestStorePlayers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *Store) {
+		alice, bob := mustAccount(t, s, "alice"), mustAccount(t, s, "bob")
+		lobby := mustLobby(t, s)
+		otherRoom, err := s.Rooms.Create(Room{Slug: "other", Name: "Other", Capacity: 10, CreatedBy: &alice})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		id, err := s.Players.Create(Player{ID: 42, Name: "Al", X: 10, Y: 20, Color: "teal", Health: 5}, alice, lobby.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unowned := mustPlayer(t, s, "Nobody's", 0, lobby.ID)
+		elsewhere := mustPlayer(t, s, "Elsewhere", bob, otherRoom)
+
+		p, err := s.Players.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Player{ID: id, Name: "Al", X: 10, Y: 20, Color: "teal", Health: maxHealth}
+		if p != want {
+			t.Errorf("Get = %+v, want %+v", p, want)
+		}
+		if _, err := s.Players.Get(999); !errors.Is(err, ErrPlayerNotFound) {
+			t.Errorf("unknown player: got %v, want ErrPlayerNotFound", err)
+		}
+
+		if all, _ := s.Players.List(); len(all) != 3 || all[0].ID != id || all[2].ID != elsewhere {
+			t.Errorf("List = %+v", all)
+		}
+		if inLobby, _ := s.Players.InRoom(lobby.ID); len(inLobby) != 2 || inLobby[0].ID != id || inLobby[1].ID != unowned {
+			t.Errorf("InRoom(lobby) = %+v", inLobby)
+		}
+
+		if owner, err := s.Players.Owner(id); err != nil || owner != alice {
+			t.Errorf("Owner = %d, %v, want %d", owner, err, alice)
+		}
+		if owner, err := s.Players.Owner(unowned); err != nil || owner != 0 {
+			t.Errorf("Owner of unowned player = %d, %v", owner, err)
+		}
+		if _, err := s.Players.Owner(999); !errors.Is(err, ErrPlayerNotFound) {
+			t.Errorf("Owner of unknown player: got %v, want ErrPlayerNotFound", err)
+		}
+		if room, err := s.Players.Room(elsewhere); err != nil || room != otherRoom {
+			t.Errorf("Room = %d, %v, want %d", room, err, otherRoom)
+		}
+		if _, err := s.Players.Room(999); !errors.Is(err, ErrPlayerNotFound) {
+			t.Errorf("Room of unknown player: got %v, want ErrPlayerNotFound", err)
+		}
+		if claimed, err := s.Players.Claim(id, bob); err != nil || claimed {
+			t.Errorf("claiming an owned player = %v, %v", claimed, err)
+		}
+		if claimed, err := s.Players.Claim(unowned, bob); err != nil || !claimed {
+			t.Errorf("claiming an unowned player = %v, %v", claimed, err)
+		}
+		if owner, _ := s.Players.Owner(unowned); owner != bob {
+			t.Errorf("owner after claim = %d, want %d", owner, bob)
+		}
+
+		if err := s.Players.Rename(id, "Alfred"); err != nil {
+			t.Fatal(err)
+		}
+		if p, _ := s.Players.Get(id); p.Name != "Alfred" {
+			t.Errorf("name after Rename = %q", p.Name)
+		}
+
+		for _, step := range []struct{ delta, want int }{{-30, 70}, {-100, 0}, {40, 40}, {500, maxHealth}} {
+			health, err := s.Players.ChangeHealth(id, step.delta, maxHealth)
+			if err != nil || health != step.want {
+				t.Errorf("ChangeHealth(%d) = %d, %v, want %d", step.delta, health, err, step.want)
+			}
+		}
+		if _, err := s.Players.ChangeHealth(999, 1, maxHealth); !errors.Is(err, ErrPlayerNotFound) {
+			t.Errorf("ChangeHealth of unknown player: got %v, want ErrPlayerNotFound", err)
+		}
+
+		err = s.Players.SavePositions([]PlayerPosition{{ID: id, X: 300, Y: 400}, {ID: elsewhere, X: 5, Y: 6}, {ID: 999, X: 1, Y: 1}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p, _ := s.Players.Get(id); p.X != 300 || p.Y != 400 {
+			t.Errorf("position after SavePositions = %d,%d", p.X, p.Y)
+		}
+		if p, _ := s.Players.Get(elsewhere); p.X != 5 || p.Y != 6 {
+			t.Errorf("position after SavePositions = %d,%d", p.X, p.Y)
+		}
+	})
+}
+
+func TestStoreDeletePlayer(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *Store) {
+		alice := mustAccount(t, s, "alice")
+		lobby := mustLobby(t, s)
+		id := mustPlayer(t, s, "Al", alice, lobby.ID)
+		keep := mustPlayer(t, s, "Keep", alice, lobby.ID)
+		if err := s.Accounts.SetLastPlayer(alice, &id); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Drawings.AddPoint(lobby.ID, DrawingPoint{X: 1, Y: 2, Color: "red", Size: 3, PlayerID: id}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Drawings.AddPoint(lobby.ID, DrawingPoint{X: 4, Y: 5, Color: "blue", Size: 3, PlayerID: keep}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Drawings.AddImage(lobby.ID, id, "data:image/png;base64,AAAA"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.Players.Delete(id); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Players.Get(id); !errors.Is(err, ErrPlayerNotFound) {
+			t.Errorf("deleted player still there: %v", err)
+		}
+		if last, _ := s.Accounts.LastPlayer(alice); last != nil {
+			t.Errorf("last player still points at the deleted player: %d", *last)
+		}
+		points, _ := s.Drawings.Points(lobby.ID)
+		if len(points) != 1 || points[0].Color != "blue" {
+			t.Errorf("points after deleting the player = %+v", points)
+		}
+		if _, err := s.Players.Get(keep); err != nil {
+			t.Errorf("other player went too: %v", err)
+		}
+	})
+}
+
+func TestStoreDrawings(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *Store) {
+		alice := mustAccount(t, s, "alice")
+		lobby := mustLobby(t, s)
+		other, err := s.Rooms.Create(Room{Slug: "other", Name: "Other", Capacity: 10, CreatedBy: &alice})
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := mustPlayer(t, s, "Al", alice, lobby.ID)
+
+		if err := s.Drawings.AddPoint(lobby.ID, DrawingPoint{X: 1, Y: 2, Color: "red", Size: 3, PlayerID: id}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Drawings.AddPoint(other, DrawingPoint{X: 7, Y: 8, Color: "green", Size: 1, PlayerID: id}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Drawings.AddImage(lobby.ID, id, "data:image/png;base64,AAAA"); err != nil {
+			t.Fatalf("AddImage: %v", err)
+		}
+
+		points, err := s.Drawings.Points(lobby.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := DrawingPoint{X: 1, Y: 2, Color: "red", Size: 3}
+		if len(points) != 1 || points[0] != want {
+			t.Errorf("Points = %+v, want only %+v", points, want)
+		}
+	})
+}
+
+func TestStoreRooms(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *Store) {
+		alice := mustAccount(t, s, "alice")
+		lobby := mustLobby(t, s)
+		if lobby.Name != "Lobby" || lobby.Capacity != defaultRoomCapacity || lobby.CreatedBy != nil {
+			t.Errorf("lobby = %+v", lobby)
+		}
+		if _, err := s.Rooms.Get("nowhere"); !errors.Is(err, ErrRoomNotFound) {
+			t.Errorf("unknown room: got %v, want ErrRoomNotFound", err)
+		}
+
+		id, err := s.Rooms.Create(Room{Slug: "games", Name: "Games", Capacity: 8, CreatedBy: &alice})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Rooms.Create(Room{Slug: "games", Name: "Again", Capacity: 8}); !errors.Is(err, ErrRoomExists) {
+			t.Errorf("duplicate slug: got %v, want ErrRoomExists", err)
+		}
+		room, err := s.Rooms.Get("games")
+		if err != nil || room.ID != id || room.Name != "Games" || room.Capacity != 8 || room.CreatedBy == nil || *room.CreatedBy != alice {
+			t.Errorf("Get = %+v, %v", room, err)
+		}
+
+		if err := s.Rooms.Update("games", "Board games", 12); err != nil {
+			t.Fatal(err)
+		}
+		if room, _ := s.Rooms.Get("games"); room.Name != "Board games" || room.Capacity != 12 {
+			t.Errorf("after Update: %+v", room)
+		}
+		if err := s.Rooms.Update("nowhere", "x", 1); !errors.Is(err, ErrRoomNotFound) {
+			t.Errorf("updating an unknown room: got %v, want ErrRoomNotFound", err)
+		}
+
+		rooms, err := s.Rooms.List()
+		if err != nil || len(rooms) != 2 || rooms[0].ID != lobby.ID || rooms[1].ID != id {
+			t.Errorf("List = %+v, %v", rooms, err)
+		}
+
+		player := mustPlayer(t, s, "Gamer", alice, id)
+		if err := s.Accounts.SetLastPlayer(alice, &player); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Drawings.AddPoint(id, DrawingPoint{X: 1, Y: 1, Color: "red", Size: 1, PlayerID: player}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Chat.Add(id, alice, &ChatMessage{PlayerID: player, Name: "Gamer", Text: "gg"}); err != nil {
+			t.Fatal(err)
+		}
+		lobbyPlayer := mustPlayer(t, s, "Stays", alice, lobby.ID)
+
+		if err := s.Rooms.Delete(id); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Rooms.Get("games"); !errors.Is(err, ErrRoomNotFound) {
+			t.Errorf("deleted room still there: %v", err)
+		}
+		if _, err := s.Players.Get(player); !errors.Is(err, ErrPlayerNotFound) {
+			t.Errorf("player of the deleted room still there: %v", err)
+		}
+		if last, _ := s.Accounts.LastPlayer(alice); last != nil {
+			t.Errorf("last player still points into the deleted room: %d", *last)
+		}
+		if _, err := s.Players.Get(lobbyPlayer); err != nil {
+			t.Errorf("lobby player went too: %v", err)
+		}
+		if chat, _ := s.Chat.Recent(id, 10); len(chat) != 0 {
+			t.Errorf("chat of the deleted room still there: %+v", chat)
+		}
+	})
+}
+
+func TestStoreSessions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *Store) {
+		alice, bob := mustAccount(t, s, "alice"), mustAccount(t, s, "bob")
+		now := time.Now()
+		sessions := []*Session{
+			{ID: "old", AccountID: alice, UserAgent: "firefox", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(time.Hour)},
+			{ID: "new", AccountID: alice, UserAgent: "curl", CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)},
+			{ID: "expired", AccountID: alice, CreatedAt: now.Add(-3 * time.Hour), ExpiresAt: now.Add(-time.Minute)},
+			{ID: "bob's", AccountID: bob, CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+		}
+		for _, sess := range sessions {
+			if err := s.Sessions.Create(sess); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		live, err := s.Sessions.Live("old")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if live.AccountID != alice || live.UserAgent != "firefox" || !live.ExpiresAt.Equal(sessions[0].ExpiresAt) {
+			t.Errorf("Live = %+v", live)
+		}
+		for _, id := range []string{"expired", "missing"} {
+			if _, err := s.Sessions.Live(id); !errors.Is(err, ErrInvalidSession) {
+				t.Errorf("Live(%q): got %v, want ErrInvalidSession", id, err)
+			}
+		}
+
+		list, err := s.Sessions.List(alice)
+		if err != nil || len(list) != 2 || list[0].ID != "new" || list[1].ID != "old" {
+			t.Errorf("List = %+v, %v", list, err)
+		}
+
+		if revoked, err := s.Sessions.Revoke(bob, "old"); err != nil || revoked {
+			t.Errorf("revoking another account's session = %v, %v", revoked, err)
+		}
+		if revoked, err := s.Sessions.Revoke(alice, "old"); err != nil || !revoked {
+			t.Errorf("Revoke = %v, %v", revoked, err)
+		}
+		if revoked, _ := s.Sessions.Revoke(alice, "old"); revoked {
+			t.Error("revoked the same session twice")
+		}
+		if _, err := s.Sessions.Live("old"); !errors.Is(err, ErrInvalidSession) {
+			t.Errorf("revoked session: got %v, want ErrInvalidSession", err)
+		}
+		if list, _ := s.Sessions.List(alice); len(list) != 1 || list[0].ID != "new" {
+			t.Errorf("List after Revoke = %+v", list)
+		}
+	})
+}
+
+func TestStoreChat(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *Store) {
+		alice := mustAccount(t, s, "alice")
+		lobby := mustLobby(t, s)
+		player := mustPlayer(t, s, "Al", alice, lobby.ID)
+
+		var sent []ChatMessage
+		for _, text := range []string{"one", "two", "three"} {
+			msg := ChatMessage{PlayerID: player, Name: "Al", Text: text, Shout: text == "two", x: 1, y: 2}
+			if err := s.Chat.Add(lobby.ID, alice, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.ID == 0 || msg.CreatedAt.IsZero() {
+				t.Fatalf("Add didn't set the ID and time: %+v", msg)
+			}
+			sent = append(sent, msg)
+		}
+
+		recent, err := s.Chat.Recent(lobby.ID, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recent) != 2 || recent[0].Text != "three" || recent[1].Text != "two" {
+			t.Fatalf("Recent = %+v", recent)
+		}
+		got := recent[1]
+		if got.ID != sent[1].ID || !got.Shout || got.Name != "Al" || got.x != 1 || got.y != 2 {
+			t.Errorf("Recent message = %+v, want %+v", got, sent[1])
+		}
+		if d := got.CreatedAt.Sub(sent[1].CreatedAt); d < -time.Second || d > time.Second {
+			t.Errorf("Recent time %v, Add said %v", got.CreatedAt, sent[1].CreatedAt)
+		}
+	})
+}
+
+func TestStoreMessages(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s *Store) {
+		alice, bob, carol := mustAccount(t, s, "alice"), mustAccount(t, s, "bob"), mustAccount(t, s, "carol")
+
+		if err := s.Messages.Send(&DirectMessage{SenderID: alice, RecipientID: 999, Text: "hello?"}); !errors.Is(err, ErrAccountNotFound) {
+			t.Errorf("sending to an unknown account: got %v, want ErrAccountNotFound", err)
+		}
+
+		var ids []int64
+		for _, m := range []DirectMessage{
+			{SenderID: alice, RecipientID: bob, Text: "hi bob"},
+			{SenderID: alice, RecipientID: bob, Text: "are you there"},
+			{SenderID: bob, RecipientID: alice, Text: "yes"},
+			{SenderID: carol, RecipientID: bob, Text: "hi from carol"},
+		} {
+			if err := s.Messages.Send(&m); err != nil {
+				t.Fatal(err)
+			}
+			if m.ID == 0 || m.CreatedAt.IsZero() {
+				t.Fatalf("Send didn't set the ID and time: %+v", m)
+			}
+			ids = append(ids, m.ID)
+		}
+
+		unread, err := s.Messages.Unread(bob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []DMUnread{{From: alice, Count: 2}, {From: carol, Count: 1}}
+		if len(unread) != 2 || unread[0] != want[0] || unread[1] != want[1] {
+			t.Errorf("Unread = %+v, want %+v", unread, want)
+		}
+
+		last, readAt, err := s.Messages.MarkRead(bob, alice, ids[0])
+		if err != nil || last != ids[0] || readAt.IsZero() {
+			t.Errorf("MarkRead = %d, %v, %v, want %d", last, readAt, err, ids[0])
+		}
+		if last, _, err := s.Messages.MarkRead(bob, alice, ids[0]); err != nil || last != 0 {
+			t.Errorf("MarkRead with nothing new = %d, %v", last, err)
+		}
+		if unread, _ := s.Messages.Unread(bob); len(unread) != 2 || unread[0].Count != 1 {
+			t.Errorf("Unread after MarkRead = %+v", unread)
+		}
+
+		history, err := s.Messages.History(bob, alice, ids[3]+1, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 3 || history[0].ID != ids[2] || history[2].ID != ids[0] {
+			t.Fatalf("History = %+v", history)
+		}
+		if history[2].ReadAt == nil || history[1].ReadAt != nil {
+			t.Errorf("read times = %v, %v", history[2].ReadAt, history[1].ReadAt)
+		}
+		page, err := s.Messages.History(alice, bob, ids[2], 1)
+		if err != nil || len(page) != 1 || page[0].ID != ids[1] {
+			t.Errorf("History page before %d = %+v, %v", ids[2], page, err)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"context"
+	"time"
+)
+
+// Snapshot is the full world state, sent on join and on request.
+type Snapshot struct {
+	Tick    uint64   `json:"tick"`
+	Players []Player `json:"players"`
+}
+
+// TickDelta lists the players that moved during one tick.
+type TickDelta struct {
+	Tick  uint64           `json:"tick"`
+	Moves []PlayerPosition `json:"moves"`
+}
+
+type GetSnapshotMessage struct{}
+
+func (m *GetSnapshotMessage) Validate() error { return nil }
+
+func (h *Hub) registerTickHandlers() {
+	h.Handle("get_snapshot", func() Payload { return &GetSnapshotMessage{} }, h.handleGetSnapshot)
+}
+
+func (h *Hub) handleGetSnapshot(req *Request) error {
+	req.Client.Send(WSMessage{Type: "snapshot", Data: h.snapshot(req.Client)})
+	return nil
+}
+
+// snapshot is the state of the players in c's view, which from then on are
+// the ones c is kept up to date on.
+func (h *Hub) snapshot(c *Client) Snapshot {
+	return Snapshot{Tick: h.tick.Load(), Players: h.visiblePlayers(c)}
+}
+
+// run advances the simulation once per tick, and saves moved players every
+// PositionFlushInterval, until ctx is done.
+func (h *Hub) run(ctx context.Context) {
+	ticker := h.clock.NewTicker(time.Second / time.Duration(h.config.TickRate))
+	defer ticker.Stop()
+	flush := h.clock.NewTicker(h.config.PositionFlushInterval)
+	defer flush.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			h.step()
+		case <-flush.C():
+			h.flushPositions()
+		}
+	}
+}
+
+// step runs one tick: projectiles move, every client gets the positions that
+// changed in its view since the previous tick and other instances get the
+// moves made here, then call ranges and idle accounts are updated. Quiet
+// ticks send nothing.
+func (h *Hub) step() {
+	tick := h.tick.Add(1)
+	h.projectiles.Step()
+	moves, local := h.world.drainMoved()
+	h.sendViews(tick, moves)
+	for len(local) > 0 {
+		n := min(len(local), maxRelayedMoves)
+		h.publish(roomEvent{Moves: local[:n]})
+		local = local[n:]
+	}
+	h.peers.Step()
+	h.checkIdle()
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"sync"
+	"time"
+)
+
+// world is the hub's in-memory copy of every player's live state. The
+// database is still written for durability, but simulations read from here.
+type world struct {
+	mu       sync.RWMutex
+	players  map[int]*Player
+	moved    map[int]bool      // players moved since the last drainMoved; true if moved here
+	lastMove map[int]time.Time // when each player's last move was accepted
+	travel   map[int]float64   // each player's travel allowance left after that move
+	grid     *spatialGrid
+}
+
+// PlayerPosition is one entry of a tick delta.
+type PlayerPosition struct {
+	ID int `json:"id"`
+	X  int `json:"x"`
+	Y  int `json:"y"`
+}
+
+func newWorld(cellSize int) *world {
+	return &world{
+		players:  make(map[int]*Player),
+		moved:    make(map[int]bool),
+		lastMove: make(map[int]time.Time),
+		travel:   make(map[int]float64),
+		grid:     newSpatialGrid(cellSize),
+	}
+}
+
+func (w *world) load(players []Player) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.players = make(map[int]*Player, len(players))
+	w.grid = newSpatialGrid(w.grid.size)
+	for i := range players {
+		p := players[i]
+		w.players[p.ID] = &p
+		w.grid.set(p.ID, p.X, p.Y)
+	}
+}
+
+func (w *world) get(id int) (Player, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	p, ok := w.players[id]
+	if !ok {
+		return Player{}, false
+	}
+	return *p, true
+}
+
+func (w *world) all() []Player {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	players := make([]Player, 0, len(w.players))
+	for _, p := range w.players {
+		players = append(players, *p)
+	}
+	return players
+}
+
+func (w *world) add(p Player) {
+	w.mu.Lock()
+	w.players[p.ID] = &p
+	w.grid.set(p.ID, p.X, p.Y)
+	w.mu.Unlock()
+}
+
+// within returns the players positioned inside r.
+func (w *world) within(r rect) []Player {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var players []Player
+	w.grid.query(r, func(id int) {
+		if p := w.players[id]; r.contains(p.X, p.Y) {
+			players = append(players, *p)
+		}
+	})
+	return players
+}
+
+// update applies fn to player id, reporting false if there is no such player.
+func (w *world) update(id int, fn func(p *Player)) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	p, ok := w.players[id]
+	if ok {
+		fn(p)
+	}
+	return ok
+}
+
+// move applies a requested position within rules and marks the player for
+// the next tick delta. It returns where the player ended up and, if that
+// isn't where it asked to go, why not.
+func (w *world) move(id, x, y int, now time.Time, rules movementRules) (PlayerPosition, string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	p, ok := w.players[id]
+	if !ok {
+		return PlayerPosition{}, "", false
+	}
+
+	allowance := rules.burst()
+	if last, ok := w.lastMove[id]; ok {
+		allowance = rules.refill(w.travel[id], now.Sub(last))
+	}
+	x, y, travelled, reason := rules.limit(p.X, p.Y, x, y, allowance)
+
+	p.X, p.Y = x, y
+	w.grid.set(id, x, y)
+	w.lastMove[id] = now
+	w.travel[id] = max(0, allowance-travelled)
+	w.moved[id] = true
+	return PlayerPosition{ID: id, X: x, Y: y}, reason, true
+}
+
+// place puts a player where another instance moved it, trusting that
+// instance to have applied the movement rules.
+func (w *world) place(m PlayerPosition) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	p, ok := w.players[m.ID]
+	if !ok {
+		return
+	}
+	p.X, p.Y = m.X, m.Y
+	w.grid.set(m.ID, m.X, m.Y)
+	if !w.moved[m.ID] {
+		w.moved[m.ID] = false
+	}
+}
+
+// drainMoved returns the positions of players moved since the last call, and
+// separately those of players moved by this instance's clients.
+func (w *world) drainMoved() (moves, local []PlayerPosition) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.moved) == 0 {
+		return nil, nil
+	}
+	moves = make([]PlayerPosition, 0, len(w.moved))
+	for id, here := range w.moved {
+		if p, ok := w.players[id]; ok {
+			pos := PlayerPosition{ID: id, X: p.X, Y: p.Y}
+			moves = append(moves, pos)
+			if here {
+				local = append(local, pos)
+			}
+		}
+		delete(w.moved, id)
+	}
+	return moves, local
+}
+
+func (w *world) remove(id int) {
+	w.mu.Lock()
+	delete(w.players, id)
+	delete(w.moved, id)
+	delete(w.lastMove, id)
+	delete(w.travel, id)
+	w.grid.remove(id)
+	w.mu.Unlock()
+}
@@ -2,106 +2,452 @@ package handler
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"math/rand"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"text/template"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 	"nhooyr.io/websocket"
 )
 
 type Player struct {
-	ID    int    `json:"id"`
-	Name  string `json:"name"`
-	X     int    `json:"x"`
-	Y     int    `json:"y"`
-	Color string `json:"color"`
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Color  string `json:"color"`
+	Health int    `json:"health"`
 }
 
 type WSMessage struct {
 	Type string      `json:"type"`
 	Data interface{} `json:"data"`
+	Seq  uint64      `json:"seq,omitempty"` // set on room-wide broadcasts only
+
+	shared *sharedFrames
 }
 
 type Client struct {
-	conn *websocket.Conn
-	send chan WSMessage
+	conn    *websocket.Conn
+	codec   codec
+	queue   *sendQueue
+	session *Session
+
+	resumeToken string
+	lastSeenAt  atomic.Int64 // unix nanoseconds of the last frame or pong
+
+	mu        sync.Mutex
+	playerID  int
+	view      clientView
+	heardChat int64 // newest nearby chat message the client is caught up with
+}
+
+func (c *Client) seen(at time.Time) {
+	c.lastSeenAt.Store(at.UnixNano())
+}
+
+// lastSeen is when c last sent a message or answered a ping.
+func (c *Client) lastSeen() time.Time {
+	return time.Unix(0, c.lastSeenAt.Load())
+}
+
+// AccountID returns the account the client authenticated as.
+func (c *Client) AccountID() int {
+	return c.session.AccountID
+}
+
+// ControlledPlayer returns the ID of the player this client controls, or 0.
+func (c *Client) ControlledPlayer() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.playerID
+}
+
+func (c *Client) controlPlayer(playerID int) {
+	c.mu.Lock()
+	c.playerID = playerID
+	c.mu.Unlock()
+}
+
+// releasePlayer stops controlling playerID if it is the controlled player.
+func (c *Client) releasePlayer(playerID int) {
+	c.mu.Lock()
+	if c.playerID == playerID {
+		c.playerID = 0
+	}
+	c.mu.Unlock()
 }
 
 type Hub struct {
-	clients map[*Client]bool
-	lock    sync.Mutex
-	db      *sql.DB
+	room     Room
+	capacity atomic.Int64
+	cancel   context.CancelFunc
+
+	clients  map[*Client]bool
+	closed   bool
+	lock     sync.Mutex
+	seq      uint64 // last broadcast sequence number
+	replay   *replayBuffer
+	parked   map[string]parkedClient // by resume token
+	store    *Store
+	sessions *SessionManager
+	dms      *DirectMessenger
+	statuses *StatusStore
+
+	bus            PubSub
+	unsubscribeBus func()
+
+	routes     map[string]route
+	middleware []Middleware
+	routesLock sync.RWMutex
+
+	owners     map[int]int // player ID -> owning account ID, 0 if unowned
+	ownersLock sync.Mutex
+
+	presence          map[int]*presenceEntry      // by account ID
+	remotePresence    map[int]map[string]Presence // by account ID, then instance
+	presenceLock      sync.Mutex
+	unsubscribeStatus func()
+
+	config      HubConfig
+	movement    movementRules
+	clock       Clock
+	world       *world
+	positions   *positionWriter
+	projectiles *ProjectileSim
+	peers       *ProximityTracker
+	tick        atomic.Uint64
 }
 
-func NewHub(db *sql.DB) *Hub {
-	return &Hub{
-		clients: make(map[*Client]bool),
-		db:      db,
+// NewHub creates the hub for one room. Rooms are normally opened through a
+// RoomManager.
+func NewHub(store *Store, sessions *SessionManager, dms *DirectMessenger, statuses *StatusStore, bus PubSub, config HubConfig, room Room) *Hub {
+	h := &Hub{
+		room:           room,
+		clients:        make(map[*Client]bool),
+		replay:         newReplayBuffer(config.ReplayBuffer),
+		parked:         make(map[string]parkedClient),
+		store:          store,
+		sessions:       sessions,
+		dms:            dms,
+		statuses:       statuses,
+		bus:            bus,
+		routes:         make(map[string]route),
+		owners:         make(map[int]int),
+		presence:       make(map[int]*presenceEntry),
+		remotePresence: make(map[int]map[string]Presence),
+		config:         config,
+		movement:       newMovementRules(config),
+		clock:          config.Clock,
+		world:          newWorld(config.GridCellSize),
+		positions:      newPositionWriter(store.Players),
+	}
+	if h.clock == nil {
+		h.clock = RealClock{}
 	}
+	h.capacity.Store(int64(room.Capacity))
+	h.projectiles = NewProjectileSim(h.clock, h.world.all, h.Broadcast, h.handleProjectileHit)
+	h.peers = NewProximityTracker(config.RTCRadius, h.controlledPlayers, h.sendToPlayer)
+	h.Use(sessions.Middleware())
+	h.registerPlayerHandlers()
+	h.registerDrawingHandlers()
+	h.registerCombatHandlers()
+	h.registerTickHandlers()
+	h.registerChatHandlers()
+	h.registerRTCHandlers()
+	h.registerDMHandlers()
+	h.registerPresenceHandlers()
+	h.registerViewHandlers()
+	return h
 }
 
-func (h *Hub) AddClient(c *Client) {
+// Start loads the room's players from the store and runs the hub's
+// simulations until ctx is done or the hub is closed.
+func (h *Hub) Start(ctx context.Context) error {
+	players, err := h.store.Players.InRoom(h.room.ID)
+	if err != nil {
+		return fmt.Errorf("loading players of room %q: %w", h.room.Slug, err)
+	}
+	h.world.load(players)
+	log.Printf("🌍 Loaded %d players into room %q", len(players), h.room.Slug)
+
+	if err := h.startRelay(); err != nil {
+		return err
+	}
+	ctx, h.cancel = context.WithCancel(ctx)
+	h.unsubscribeStatus = h.statuses.subscribe(h.applyStatus)
+	go h.run(ctx)
+	return nil
+}
+
+// Close stops the hub's simulations, saves player positions and disconnects
+// every client. Clients are closed in parallel, since each close waits for
+// the client to answer the close frame.
+func (h *Hub) Close(code websocket.StatusCode, reason string) {
+	if h.cancel != nil {
+		h.cancel()
+	}
+	h.flushPositions()
+	if h.unsubscribeStatus != nil {
+		h.unsubscribeStatus()
+	}
+	if h.unsubscribeBus != nil {
+		h.unsubscribeBus()
+	}
+	h.lock.Lock()
+	h.closed = true
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.lock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.conn.Close(code, reason)
+		}()
+	}
+	wg.Wait()
+}
+
+func (h *Hub) ClientCount() int {
 	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.clients)
+}
+
+func (h *Hub) setCapacity(capacity int) {
+	h.capacity.Store(int64(capacity))
+}
+
+func (h *Hub) full() bool {
+	return h.ClientCount() >= int(h.capacity.Load())
+}
+
+// AddClient registers c with the hub, reporting false if the room is full or
+// the hub has closed.
+// A welcome is queued first; if c is resuming, every broadcast after lastSeq
+// follows it, and resumed reports whether they were all still buffered.
+func (h *Hub) AddClient(c *Client, lastSeq uint64, resuming bool) (added, resumed bool) {
+	h.lock.Lock()
+	if h.closed || len(h.clients) >= int(h.capacity.Load()) {
+		h.lock.Unlock()
+		return false, false
+	}
+	var missed []WSMessage
+	if resuming {
+		missed, resumed = h.replay.since(lastSeq)
+	}
+	c.queue = h.newQueue(c)
+	c.Send(WSMessage{Type: "welcome", Data: Welcome{ResumeToken: c.resumeToken, Seq: h.seq, Resumed: resumed}})
+	for _, msg := range missed {
+		c.Send(msg)
+	}
 	h.clients[c] = true
 	h.lock.Unlock()
-	go c.writeLoop()
+	h.dms.connect(c)
+	go c.writeLoop(h.config.WriteTimeout, h.config.BatchWindow, h.config.MaxBatch)
+	return true, resumed
+}
+
+// newQueue makes c's send queue, which closes c with StatusSlowConsumer once
+// it falls too far behind.
+func (h *Hub) newQueue(c *Client) *sendQueue {
+	return newSendQueue(h.config.Backpressure, h.clock, func(reason string) {
+		log.Printf("🐢 Closing slow client of account %d in room %q: %s", c.AccountID(), h.room.Slug, reason)
+		go c.conn.Close(StatusSlowConsumer, reason)
+	})
 }
 
 func (h *Hub) RemoveClient(c *Client) {
 	h.lock.Lock()
 	delete(h.clients, c)
+	h.park(c)
 	h.lock.Unlock()
+	c.queue.close()
+	h.dms.disconnect(c)
 	c.conn.Close(websocket.StatusNormalClosure, "")
 }
 
+// Broadcast sends msg to every client in the room, on this instance and the
+// others.
 func (h *Hub) Broadcast(msg WSMessage) {
+	h.broadcastLocal(msg)
+	h.publish(roomEvent{Message: &msg})
+}
+
+// broadcastLocal sends msg to this instance's clients, numbering it and
+// keeping it for clients that reconnect.
+func (h *Hub) broadcastLocal(msg WSMessage) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.seq++
+	msg.Seq = h.seq
+	h.replay.add(msg)
+	h.deliver(msg, func(*Client) bool { return true })
+}
+
+// sendWhere sends msg to every client for which include returns true. These
+// messages aren't numbered or replayed.
+func (h *Hub) sendWhere(msg WSMessage, include func(*Client) bool) {
 	h.lock.Lock()
 	defer h.lock.Unlock()
+	h.deliver(msg, include)
+}
+
+// deliver queues msg for the clients include selects. Callers hold h.lock.
+func (h *Hub) deliver(msg WSMessage, include func(*Client) bool) {
+	msg.shared = &sharedFrames{}
 	for client := range h.clients {
-		select {
-		case client.send <- msg:
-		default:
-			close(client.send)
-			delete(h.clients, client)
+		if !include(client) {
+			continue
 		}
+		client.Send(msg)
 	}
 }
 
-func (c *Client) writeLoop() {
-	for msg := range c.send {
-		data, err := json.Marshal(msg)
+// writeLoop writes queued messages until the queue is closed. Messages that
+// arrive within window of each other go out together as one batch frame of
+// at most maxBatch. A write that takes longer than timeout closes the
+// connection.
+func (c *Client) writeLoop(timeout, window time.Duration, maxBatch int) {
+	maxBatch = max(maxBatch, 1)
+	for !c.queue.isClosed() {
+		msgs := c.queue.pop(maxBatch)
+		if len(msgs) == 0 {
+			<-c.queue.ready
+			continue
+		}
+		if window > 0 && len(msgs) < maxBatch {
+			time.Sleep(window)
+			msgs = append(msgs, c.queue.pop(maxBatch-len(msgs))...)
+		}
+		data, err := encodeFrame(c.codec, msgs)
 		if err != nil {
 			log.Println("write error marshal:", err)
 			continue
 		}
-		c.conn.Write(context.Background(), websocket.MessageText, data)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err = c.conn.Write(ctx, c.codec.frameType(), data)
+		cancel()
+		if err != nil {
+			log.Printf("⚠️ Write to client of account %d failed: %v", c.AccountID(), err)
+			c.conn.CloseNow()
+			return
+		}
 	}
 }
 
+// Send queues msg for this client only. Clients that fall too far behind are
+// closed by their queue's backpressure policy.
+func (c *Client) Send(msg WSMessage) {
+	c.queue.push(msg)
+}
+
+func (c *Client) sendError(msgErr *MessageError) {
+	c.Send(WSMessage{Type: "error", Data: msgErr})
+}
+
+// authenticateUpgrade checks that r may open a WebSocket and whose session
+// it carries, answering it and returning false if not.
+func authenticateUpgrade(w http.ResponseWriter, r *http.Request, upgrade UpgradeConfig, sessions *SessionManager) (*Session, bool) {
+	if err := upgrade.checkUpgrade(r); err != nil {
+		log.Printf("🚫 Rejected WebSocket upgrade from %s: %s", r.RemoteAddr, err)
+		http.Error(w, err.message, err.status)
+		return nil, false
+	}
+
+	session, err := sessions.FromRequest(r)
+	if err != nil {
+		if !errors.Is(err, ErrInvalidSession) {
+			log.Println("WebSocket session lookup error:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return nil, false
+		}
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return nil, false
+	}
+	return session, true
+}
+
 func (h *Hub) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
-	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		InsecureSkipVerify: true, // safe for local dev
-	})
+	session, ok := authenticateUpgrade(w, r, h.config.Upgrade, h.sessions)
+	if !ok {
+		return
+	}
+	h.serve(w, r, session)
+}
+
+// serve runs the connection of an authenticated client until it closes.
+func (h *Hub) serve(w http.ResponseWriter, r *http.Request, session *Session) {
+	if h.full() {
+		http.Error(w, "Room is full", http.StatusConflict)
+		return
+	}
+
+	conn, err := websocket.Accept(w, r, h.config.Upgrade.acceptOptions())
 	if err != nil {
 		log.Println("WebSocket accept error:", err)
 		return
 	}
+	conn.SetReadLimit(h.config.Upgrade.MaxMessageSize)
 
 	defer conn.Close(websocket.StatusInternalError, "unexpected close")
 
 	client := &Client{
-		conn: conn,
-		send: make(chan WSMessage, 16),
+		conn:        conn,
+		codec:       codecFor(conn.Subprotocol()),
+		session:     session,
+		resumeToken: newResumeToken(),
+		view:        clientView{width: h.config.ViewWidth, height: h.config.ViewHeight},
+	}
+	token, lastSeq, resuming := resumeRequest(r.URL.Query().Get("resume"), r.URL.Query().Get("seq"))
+	if resuming {
+		var parked parkedClient
+		if parked, resuming = h.unpark(token, session.AccountID); resuming {
+			if _, ok := h.world.get(parked.playerID); ok {
+				client.controlPlayer(parked.playerID)
+			}
+			client.hear(parked.heardChat)
+		}
+	}
+	if !resuming {
+		h.resumeLastPlayer(client)
+	}
+	client.seen(h.clock.Now())
+	added, resumed := h.AddClient(client, lastSeq, resuming)
+	if !added {
+		conn.Close(websocket.StatusTryAgainLater, "room is full or closing")
+		return
+	}
+	if err := h.joinPresence(client); err != nil {
+		log.Printf("❌ %v", err)
+		h.RemoveClient(client)
+		return
+	}
+	defer h.teardown(client)
+	// The replay only holds room broadcasts: nearby chat and direct messages
+	// sent while a resumed client was away are caught up on separately.
+	if resumed {
+		h.sendMissedChat(client)
+	} else {
+		client.Send(WSMessage{Type: "snapshot", Data: h.snapshot(client)})
+		h.sendChatHistory(client)
 	}
-	h.AddClient(client)
-	defer h.RemoveClient(client)
+	h.sendUnreadDMs(client)
 
-	ctx := r.Context()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go h.heartbeat(ctx, client)
 	for {
 		_, msg, err := conn.Read(ctx)
 		if err != nil {
@@ -115,272 +461,18 @@ func (h *Hub) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 
-		var req WSMessage
-		if err := json.Unmarshal(msg, &req); err != nil {
-			log.Println("bad ws message:", err)
-			continue
-		}
-
-		switch req.Type {
-		case "move":
-			m := req.Data.(map[string]interface{})
-			id := int(m["id"].(float64))
-			x := int(m["x"].(float64))
-			y := int(m["y"].(float64))
-			
-			// Broadcast immediately without waiting for DB
-			h.Broadcast(WSMessage{
-				Type: "move",
-				Data: map[string]interface{}{"id": id, "x": x, "y": y},
-			})
-			
-			// Update DB asynchronously (non-blocking)
-			go func() {
-				_, err := h.db.Exec("UPDATE player SET x = $1, y = $2 WHERE id = $3", x, y, id)
-				if err != nil {
-					log.Println("async update error:", err)
-				}
-			}()
-		case "create":
-			data := req.Data.(map[string]interface{})
-			name := data["name"].(string)
-			accountID := int(data["accountId"].(float64))
-			
-			log.Printf("👤 Creating player: name='%s', accountId=%d", name, accountID)
-			
-			colors := []string{
-				"teal", "tomato", "orange", "green", "gold", "pink",
-				"cyan", "magenta", "lime", "coral", "brown", "orchid",
-				"lightblue", "lightgreen", "khaki", "peachpuff", "lavender"}
-			color := colors[rand.Intn(len(colors))]
-
-			x := rand.Intn(800)
-			y := rand.Intn(600)
-			var id int
-			
-			err := h.db.QueryRow("INSERT INTO player (name, x, y, color) VALUES ($1, $2, $3, $4) RETURNING id", 
-				name, x, y, color).Scan(&id)
-			if err != nil {
-				log.Printf("❌ Error creating player: %v", err)
-				continue
-			}
-			
-			log.Printf("✅ Player created successfully: id=%d, name='%s', position=(%d,%d)", id, name, x, y)
-			
-			// Update account's last_player_id
-			_, err = h.db.Exec("UPDATE account SET last_player_id = $1 WHERE id = $2", id, accountID)
-			if err != nil {
-				log.Printf("❌ Error updating account last_player_id: %v", err)
-			} else {
-				log.Printf("✅ Updated account %d last_player_id to %d", accountID, id)
-			}
-			
-			player := Player{ID: id, Name: name, X: x, Y: y, Color: color}
-			client.send <- WSMessage{Type: "created", Data: player}
-			h.Broadcast(WSMessage{Type: "new_player", Data: player})
-			
-			log.Printf("📤 Sent player creation messages for player %d", id)
-		case "change_name":
-			data := req.Data.(map[string]interface{})
-			id := int(data["id"].(float64))
-			name := data["name"].(string)
-			_, _ = h.db.Exec("UPDATE player SET name = $1 WHERE id = $2", name, id)
-			h.Broadcast(WSMessage{Type: "name_changed", Data: map[string]interface{}{"id": id, "name": name}})
-		case "get_players":
-			log.Printf("📋 Getting all players from database")
-			
-			rows, err := h.db.Query("SELECT id, name, x, y, color FROM player")
-			if err != nil {
-				log.Println("❌ db query error:", err)
-				continue
-			}
-			defer rows.Close()
-
-			var chars []Player
-			for rows.Next() {
-				var c Player
-				if err := rows.Scan(&c.ID, &c.Name, &c.X, &c.Y, &c.Color); err != nil {
-					log.Println("❌ db scan error:", err)
-					continue
-				}
-				chars = append(chars, c)
-			}
-			
-			log.Printf("📋 Found %d players in database: %v", len(chars), func() []int {
-				ids := make([]int, len(chars))
-				for i, p := range chars {
-					ids[i] = p.ID
-				}
-				return ids
-			}())
-			
-			client.send <- WSMessage{Type: "players", Data: chars}
-		case "control_player":
-			data := req.Data.(map[string]interface{})
-			playerID := int(data["playerId"].(float64))
-			accountID := int(data["accountId"].(float64))
-			
-			log.Printf("🎮 control_player: account %d trying to control player %d", accountID, playerID)
-			
-			// Check if player exists before updating foreign key
-			var exists bool
-			err := h.db.QueryRow("SELECT EXISTS(SELECT 1 FROM player WHERE id = $1)", playerID).Scan(&exists)
-			if err != nil {
-				log.Printf("❌ Error checking if player %d exists: %v", playerID, err)
-				continue
-			}
-			
-			if !exists {
-				log.Printf("❌ Cannot set last_player_id to %d - player does not exist in database", playerID)
-				// Set last_player_id to NULL instead of non-existent player
-				_, err = h.db.Exec("UPDATE account SET last_player_id = NULL WHERE id = $1", accountID)
-				if err != nil {
-					log.Printf("❌ Error setting last_player_id to NULL for account %d: %v", accountID, err)
-				} else {
-					log.Printf("✅ Set last_player_id to NULL for account %d", accountID)
-				}
-				continue
-			}
-			
-			// Update account's last controlled player
-			_, err = h.db.Exec("UPDATE account SET last_player_id = $1 WHERE id = $2", playerID, accountID)
-			if err != nil {
-				log.Printf("❌ Error updating last player: account=%d, player=%d, error=%v", accountID, playerID, err)
-			} else {
-				log.Printf("✅ Successfully updated account %d last_player_id to %d", accountID, playerID)
-			}
-			
-		case "save_drawing":
-			data := req.Data.(map[string]interface{})
-			playerID := int(data["playerId"].(float64))
-			image := data["image"].(string)
-
-			_, err := h.db.Exec("INSERT INTO drawing (player_id, image) VALUES ($1, $2)", playerID, image)
-			if err != nil {
-				log.Println("error saving drawing:", err)
-			}
-
-		case "delete_player":
-			data := req.Data.(map[string]interface{})
-			id := int(data["id"].(float64))
-			
-			log.Printf("💀 Deleting dead player %d from database", id)
-			
-			// Start transaction to handle foreign key constraints
-			tx, err := h.db.Begin()
-			if err != nil {
-				log.Printf("❌ Error starting transaction for player %d deletion: %v", id, err)
-				continue
-			}
-			
-			// First delete any drawings by this player
-			_, err = tx.Exec("DELETE FROM drawing WHERE player_id = $1", id)
-			if err != nil {
-				tx.Rollback()
-				log.Printf("❌ Error deleting drawings for player %d: %v", id, err)
-				continue
-			}
-			
-			// Clear any account references to this player
-			_, err = tx.Exec("UPDATE account SET last_player_id = NULL WHERE last_player_id = $1", id)
-			if err != nil {
-				tx.Rollback()
-				log.Printf("❌ Error clearing account references for player %d: %v", id, err)
-				continue
-			}
-			
-			// Delete the player
-			_, err = tx.Exec("DELETE FROM player WHERE id = $1", id)
-			if err != nil {
-				tx.Rollback()
-				log.Printf("❌ Error deleting player %d: %v", id, err)
-				continue
-			}
-			
-			// Commit transaction
-			if err = tx.Commit(); err != nil {
-				log.Printf("❌ Error committing player %d deletion: %v", id, err)
-				continue
-			}
-			
-			log.Printf("✅ Successfully deleted player %d from database", id)
-			
-			// Broadcast player deletion to all clients
-			h.Broadcast(WSMessage{
-				Type: "player_deleted",
-				Data: map[string]interface{}{"id": id},
-			})
-
-		case "health_change":
-			data := req.Data.(map[string]interface{})
-			playerId := int(data["playerId"].(float64))
-			newHealth := int(data["health"].(float64))
-			changeType := data["type"].(string) // "damage" or "heal"
-			
-			// Broadcast health change to all clients
-			h.Broadcast(WSMessage{
-				Type: "health_change",
-				Data: map[string]interface{}{
-					"playerId": playerId,
-					"health": newHealth,
-					"type": changeType,
-				},
-			})
-
-		case "spawn_bullet":
-			data := req.Data.(map[string]interface{})
-			fromId := int(data["fromId"].(float64))
-			targetX := data["targetX"].(float64)
-			targetY := data["targetY"].(float64)
-			
-			// Broadcast bullet spawn to all clients
-			h.Broadcast(WSMessage{
-				Type: "spawn_bullet",
-				Data: map[string]interface{}{
-					"fromId": fromId,
-					"targetX": targetX,
-					"targetY": targetY,
-				},
-			})
-
-		case "spawn_medkit":
-			data := req.Data.(map[string]interface{})
-			fromId := int(data["fromId"].(float64))
-			targetX := data["targetX"].(float64)
-			targetY := data["targetY"].(float64)
-			
-			// Broadcast med kit spawn to all clients
-			h.Broadcast(WSMessage{
-				Type: "spawn_medkit",
-				Data: map[string]interface{}{
-					"fromId": fromId,
-					"targetX": targetX,
-					"targetY": targetY,
-				},
-			})
-
-		}
+		client.seen(h.clock.Now())
+		h.dispatch(client, msg)
 	}
 }
 
-func Home(db *sql.DB) http.HandlerFunc {
+func Home(store *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query("SELECT id, name, x, y, color FROM player")
+		chars, err := store.Players.List()
 		if err != nil {
 			http.Error(w, "Failed to query player: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
-
-		var chars []Player
-		for rows.Next() {
-			var c Player
-			if err := rows.Scan(&c.ID, &c.Name, &c.X, &c.Y, &c.Color); err != nil {
-				http.Error(w, "Failed to scan row: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-			chars = append(chars, c)
-		}
 		tmpl := template.Must(template.ParseFiles("templates/home.html"))
 		tmpl.Execute(w, chars)
 	}
@@ -399,11 +491,13 @@ type AuthRequest struct {
 }
 
 type AuthResponse struct {
-	AccountID int    `json:"accountId"`
-	Error     string `json:"error,omitempty"`
+	AccountID int        `json:"accountId"`
+	Token     string     `json:"token,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Error     string     `json:"error,omitempty"`
 }
 
-func LoginHandler(db *sql.DB) http.HandlerFunc {
+func LoginHandler(store *Store, sessions *SessionManager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -418,11 +512,8 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		var account Account
-		err := db.QueryRow("SELECT id, username, password_hash, last_player_id FROM account WHERE username = $1", 
-			req.Username).Scan(&account.ID, &account.Username, &account.PasswordHash, &account.LastPlayerID)
-		
-		if err == sql.ErrNoRows {
+		account, err := store.Accounts.ByUsername(req.Username)
+		if errors.Is(err, ErrAccountNotFound) {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(AuthResponse{Error: "Invalid credentials"})
@@ -441,12 +532,22 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		token, sess, err := sessions.Issue(account.ID, r.UserAgent())
+		if err != nil {
+			log.Printf("❌ Error issuing session for account %d: %v", account.ID, err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(AuthResponse{Error: "Database error"})
+			return
+		}
+		sessions.setCookie(w, r, token, sess.ExpiresAt)
+
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(AuthResponse{AccountID: account.ID})
+		json.NewEncoder(w).Encode(AuthResponse{AccountID: account.ID, Token: token, ExpiresAt: &sess.ExpiresAt})
 	}
 }
 
-func RegisterHandler(db *sql.DB) http.HandlerFunc {
+func RegisterHandler(store *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -476,11 +577,9 @@ func RegisterHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		_, err = db.Exec("INSERT INTO account (username, password_hash) VALUES ($1, $2)", 
-			req.Username, string(hashedPassword))
-		
+		_, err = store.Accounts.Create(req.Username, string(hashedPassword))
 		if err != nil {
-			if err.Error() == "pq: duplicate key value violates unique constraint \"account_username_key\"" {
+			if errors.Is(err, ErrUsernameTaken) {
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusConflict)
 				json.NewEncoder(w).Encode(AuthResponse{Error: "Username already exists"})
@@ -497,50 +596,37 @@ func RegisterHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-func OurgatherPage(db *sql.DB) http.HandlerFunc {
+func OurgatherPage(store *Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query("SELECT id, name, x, y, color FROM player")
+		chars, err := store.Players.List()
 		if err != nil {
 			http.Error(w, "Failed to query player: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
-
-		var chars []Player
-		for rows.Next() {
-			var c Player
-			if err := rows.Scan(&c.ID, &c.Name, &c.X, &c.Y, &c.Color); err != nil {
-				http.Error(w, "Failed to scan row: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-			chars = append(chars, c)
-		}
 
 		tmpl := template.Must(template.ParseFiles("templates/ourgatther.html"))
 		tmpl.Execute(w, chars)
 	}
 }
 
-func AccountInfoHandler(db *sql.DB) http.HandlerFunc {
+func AccountInfoHandler(store *Store, sessions *SessionManager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		accountID := r.URL.Query().Get("accountId")
-		if accountID == "" {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Account ID required"})
+		sess, err := sessions.FromRequest(r)
+		if err != nil {
+			writeUnauthorized(w)
 			return
 		}
+		accountID := sess.AccountID
 
-		var lastPlayerID *int
 		var playerData *Player
-		
+
 		// Get account's last player ID
-		err := db.QueryRow("SELECT last_player_id FROM account WHERE id = $1", accountID).Scan(&lastPlayerID)
+		lastPlayerID, err := store.Accounts.LastPlayer(accountID)
 		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
@@ -550,9 +636,7 @@ func AccountInfoHandler(db *sql.DB) http.HandlerFunc {
 
 		// If there's a last player, get their info
 		if lastPlayerID != nil {
-			var player Player
-			err = db.QueryRow("SELECT id, name, x, y, color FROM player WHERE id = $1", *lastPlayerID).Scan(
-				&player.ID, &player.Name, &player.X, &player.Y, &player.Color)
+			player, err := store.Players.Get(*lastPlayerID)
 			if err == nil {
 				playerData = &player
 			}
@@ -1,95 +1,282 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"errors"
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"ourgatther/handler"
+	"ourgatther/migrate"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"nhooyr.io/websocket"
 )
 
-func databaseChanges(db *sql.DB) error {
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS account (
-			id SERIAL PRIMARY KEY,
-			username TEXT UNIQUE NOT NULL,
-			password_hash TEXT NOT NULL,
-			last_player_id INT,
-			created_at TIMESTAMP DEFAULT NOW()
-		);
-
-		CREATE TABLE IF NOT EXISTS player (
-			id SERIAL PRIMARY KEY,
-			name TEXT NOT NULL,
-			x INT NOT NULL DEFAULT 100,
-			y INT NOT NULL DEFAULT 100,
-			color TEXT NOT NULL DEFAULT 'teal',
-			account_id INT REFERENCES account(id),
-			created_at TIMESTAMP DEFAULT NOW()
-		);
-
-		CREATE TABLE IF NOT EXISTS drawing (
-			id SERIAL PRIMARY KEY,
-			player_id INT REFERENCES player(id),
-			x INT NOT NULL,
-			y INT NOT NULL,
-			color TEXT NOT NULL DEFAULT 'black',
-			size INT NOT NULL DEFAULT 2,
-			image TEXT NOT NULL  -- optional: can hold base64 or other encoding of full image
-		);
-
-		-- Add account_id column to player table if it doesn't exist
-		DO $$ BEGIN
-			IF NOT EXISTS (SELECT 1 FROM information_schema.columns 
-						   WHERE table_name = 'player' AND column_name = 'account_id') THEN
-				ALTER TABLE player ADD COLUMN account_id INT REFERENCES account(id);
-			END IF;
-		END $$;
-
-		-- Add foreign key constraint for account's last_player_id (if not exists)
-		DO $$ BEGIN
-			IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'account_last_player_fkey') THEN
-				ALTER TABLE account ADD CONSTRAINT account_last_player_fkey 
-					FOREIGN KEY (last_player_id) REFERENCES player(id);
-			END IF;
-		END $$;
-
-	`)
-	return err
+// runMigrate implements "ourgatther migrate up|down|status".
+func runMigrate(db *sql.DB, dialect migrate.Dialect, args []string) error {
+	migrator, err := migrate.New(db, dialect)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	switch {
+	case len(args) == 1 && args[0] == "up":
+		applied, err := migrator.Up(ctx)
+		if err == nil && len(applied) == 0 {
+			fmt.Println("Already up to date")
+		}
+		return err
+	case len(args) == 1 && args[0] == "down":
+		_, err := migrator.Down(ctx)
+		return err
+	case len(args) == 1 && args[0] == "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%-20s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	}
+	return fmt.Errorf("usage: %s migrate up|down|status", os.Args[0])
+}
+
+// openStore picks the storage for DATABASE_URL by its scheme. "memory:"
+// keeps everything in memory, lost on restart, and has to be asked for so a
+// missing DATABASE_URL can't quietly lose data. "sqlite:" names a database
+// file, e.g. sqlite:ourgatther.db or sqlite:///var/lib/ourgatther.db;
+// anything else is a Postgres URL. db is returned for migrations and nil for
+// memory.
+func openStore(dsn string) (store *handler.Store, db *sql.DB, dialect migrate.Dialect, err error) {
+	switch {
+	case dsn == "":
+		return nil, nil, dialect, errors.New("DATABASE_URL is not set; use memory: to keep everything in memory")
+
+	case strings.HasPrefix(dsn, "memory:"):
+		return handler.NewMemoryStore(), nil, dialect, nil
+
+	case strings.HasPrefix(dsn, "sqlite:"):
+		db, err = handler.OpenSQLite(strings.TrimPrefix(strings.TrimPrefix(dsn, "sqlite:"), "//"))
+		if err != nil {
+			return nil, nil, dialect, err
+		}
+		return handler.NewSQLiteStore(db), db, migrate.SQLite, nil
+	}
+
+	db, err = sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, nil, dialect, err
+	}
+	return handler.NewPostgresStore(db), db, migrate.Postgres, nil
+}
+
+const sessionTTL = 7 * 24 * time.Hour
+
+// sessionSecret returns the key used to sign session tokens. Without
+// SESSION_SECRET a random key is used, so sessions don't survive a restart.
+func sessionSecret() []byte {
+	if secret := os.Getenv("SESSION_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	log.Println("⚠️ SESSION_SECRET not set, using a random key; sessions will not survive a restart")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatal(err)
+	}
+	return secret
+}
+
+// adminServer starts the listener for /debug/vars on ADMIN_ADDR, by default
+// only reachable from this machine. ADMIN_ADDR set but empty turns it off.
+func adminServer() *http.Server {
+	addr, ok := os.LookupEnv("ADMIN_ADDR")
+	if !ok {
+		addr = "127.0.0.1:6060"
+	}
+	if addr == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Printf("❌ Admin listener on %s: %v", addr, err)
+		}
+	}()
+	log.Printf("📊 Metrics at http://%s/debug/vars", addr)
+	return server
 }
 
 func main() {
 	_ = godotenv.Load()
 
-	db, err := sql.Open("postgres", os.Getenv("DATABASE_URL"))
+	store, db, dialect, err := openStore(os.Getenv("DATABASE_URL"))
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
+	if db != nil {
+		defer db.Close()
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if db == nil {
+			log.Fatal("migrate needs a DATABASE_URL")
+		}
+		if err := runMigrate(db, dialect, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	if err := databaseChanges(db); err != nil {
-		log.Fatalf("Failed to create tables: %v", err)
+	if db != nil {
+		migrator, err := migrate.New(db, dialect)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err := migrator.Up(context.Background()); err != nil {
+			log.Fatalf("Failed to migrate the database: %v", err)
+		}
+	} else {
+		log.Println("⚠️ Keeping everything in memory; it will be lost on restart")
 	}
 
-	hub := handler.NewHub(db)
-	http.HandleFunc("/draw", handler.DrawHandler(db))
-	http.HandleFunc("/drawings", handler.GetAllDrawingsHandler(db))
-	
+	sessions := handler.NewSessionManager(store.Sessions, sessionSecret(), sessionTTL)
+
+	hubConfig := handler.DefaultHubConfig()
+	if rate, err := strconv.Atoi(os.Getenv("TICK_RATE")); err == nil && rate > 0 {
+		hubConfig.TickRate = rate
+	}
+	if radius, err := strconv.ParseFloat(os.Getenv("CHAT_RADIUS"), 64); err == nil && radius > 0 {
+		hubConfig.ChatRadius = radius
+	}
+	if radius, err := strconv.ParseFloat(os.Getenv("RTC_RADIUS"), 64); err == nil && radius > 0 {
+		hubConfig.RTCRadius = radius
+	}
+	if margin, err := strconv.Atoi(os.Getenv("VIEW_MARGIN")); err == nil && margin >= 0 {
+		hubConfig.ViewMargin = margin
+	}
+	if away, err := time.ParseDuration(os.Getenv("AWAY_AFTER")); err == nil && away > 0 {
+		hubConfig.AwayAfter = away
+	}
+	for _, origin := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			hubConfig.Upgrade.AllowedOrigins = append(hubConfig.Upgrade.AllowedOrigins, origin)
+		}
+	}
+	if size, err := strconv.ParseInt(os.Getenv("WS_MAX_MESSAGE_SIZE"), 10, 64); err == nil && size > 0 {
+		hubConfig.Upgrade.MaxMessageSize = size
+	}
+	switch os.Getenv("WS_COMPRESSION") {
+	case "on":
+		hubConfig.Upgrade.Compression = websocket.CompressionNoContextTakeover
+	case "context":
+		hubConfig.Upgrade.Compression = websocket.CompressionContextTakeover
+	}
+	if window, err := time.ParseDuration(os.Getenv("WS_BATCH_WINDOW")); err == nil && window >= 0 {
+		hubConfig.BatchWindow = window
+	}
+
+	if interval, err := time.ParseDuration(os.Getenv("POSITION_FLUSH_INTERVAL")); err == nil && interval > 0 {
+		hubConfig.PositionFlushInterval = interval
+	}
+	if id := os.Getenv("INSTANCE_ID"); id != "" {
+		hubConfig.InstanceID = id
+	}
+	var bus handler.PubSub = handler.NewMemoryPubSub()
+	if os.Getenv("PUBSUB") == "postgres" {
+		if dialect != migrate.Postgres {
+			log.Fatal("PUBSUB=postgres needs a Postgres DATABASE_URL")
+		}
+		pg := handler.NewPostgresPubSub(db, os.Getenv("DATABASE_URL"))
+		defer pg.Close()
+		bus = pg
+		log.Printf("📡 Sharing rooms with other instances as %s", hubConfig.InstanceID)
+	}
+
+	dms := handler.NewDirectMessenger(store.Messages)
+	statuses := handler.NewStatusStore(store.Accounts)
+	unsubscribeDMs, err := dms.Relay(bus, hubConfig.InstanceID)
+	if err != nil {
+		log.Fatalf("Failed to relay direct messages: %v", err)
+	}
+	defer unsubscribeDMs()
+	unsubscribeStatuses, err := statuses.Relay(bus, hubConfig.InstanceID)
+	if err != nil {
+		log.Fatalf("Failed to relay status changes: %v", err)
+	}
+	defer unsubscribeStatuses()
+	rooms := handler.NewRoomManager(context.Background(), store, sessions, dms, statuses, bus, hubConfig)
+	rooms.Use(handler.MetricsMiddleware, handler.RateLimitMiddleware(30, 60))
+	if os.Getenv("WS_DEBUG") != "" {
+		rooms.Use(handler.LoggingMiddleware)
+	}
+	if _, err := rooms.Hub(handler.DefaultRoom); err != nil {
+		log.Fatalf("Failed to open the lobby: %v", err)
+	}
+	// Public routes get their own mux: expvar registers /debug/vars on the
+	// default one, which is kept off the public port.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/draw", handler.DrawHandler(store, sessions))
+	mux.HandleFunc("/drawings", handler.GetAllDrawingsHandler(store))
+
 	// Auth endpoints
-	http.HandleFunc("/login", handler.LoginHandler(db))
-	http.HandleFunc("/register", handler.RegisterHandler(db))
-	http.HandleFunc("/account-info", handler.AccountInfoHandler(db))
+	mux.HandleFunc("/login", handler.LoginHandler(store, sessions))
+	mux.HandleFunc("/logout", handler.LogoutHandler(sessions))
+	mux.HandleFunc("/sessions", handler.SessionsHandler(sessions))
+	mux.HandleFunc("/register", handler.RegisterHandler(store))
+	mux.HandleFunc("/account-info", handler.AccountInfoHandler(store, sessions))
+
+	// Rooms
+	mux.HandleFunc("/rooms", handler.RoomsHandler(rooms, sessions))
+	mux.HandleFunc("/rooms/{slug}", handler.RoomHandler(rooms, sessions))
+	mux.HandleFunc("/presence", handler.PresenceHandler(rooms))
+
+	// Direct messages
+	mux.HandleFunc("/messages", handler.DirectMessagesHandler(dms, sessions))
 
-	http.HandleFunc("/ws", hub.WebSocketHandler)
-	http.HandleFunc("/", handler.Home(db))
-	http.HandleFunc("/ourgatther", handler.OurgatherPage(db))
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	mux.HandleFunc("/ws", rooms.WebSocketHandler)
+	mux.HandleFunc("/", handler.Home(store))
+	mux.HandleFunc("/ourgatther", handler.OurgatherPage(store))
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+
+	// On SIGINT/SIGTERM stop accepting requests, then close the rooms so
+	// every player's position is saved.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := &http.Server{Addr: ":8080", Handler: mux}
+	admin := adminServer()
+	go func() {
+		<-ctx.Done()
+		log.Println("🛑 Shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if admin != nil {
+			admin.Shutdown(shutdownCtx)
+		}
+		server.Shutdown(shutdownCtx)
+	}()
 
 	fmt.Println("Server running at http://localhost:8080")
-	http.ListenAndServe(":8080", nil)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	rooms.Close()
 }
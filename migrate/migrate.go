@@ -0,0 +1,261 @@
+// Package migrate versions the database schema. Migrations are numbered SQL
+// files embedded in the binary, NNNN_name.up.sql with a matching
+// NNNN_name.down.sql, one directory per database, and the applied ones are
+// recorded in schema_migrations.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// Dialect is what differs between databases: the migrations to run and how
+// to keep two migrators apart.
+type Dialect struct {
+	Name string
+	// lock and unlock are run around migrating, if set.
+	lock, unlock string
+	// createTable creates schema_migrations if it doesn't exist.
+	createTable string
+}
+
+var (
+	// Postgres holds an advisory lock while migrating, so instances starting
+	// together don't migrate at the same time.
+	Postgres = Dialect{
+		Name:   "postgres",
+		lock:   "SELECT pg_advisory_lock(7460727)",
+		unlock: "SELECT pg_advisory_unlock(7460727)",
+		createTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+	}
+
+	// SQLite needs no lock, as only one process uses the file.
+	SQLite = Dialect{
+		Name: "sqlite",
+		createTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+	}
+)
+
+var ErrNoMigrations = errors.New("no migrations applied")
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and when it was applied, if it has been.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Load reads the migrations in dir of fsys, in version order.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: name must end in .up.sql or .down.sql", e.Name())
+		}
+		num, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must start with a version number", e.Name())
+		}
+		body, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d is named both %q and %q", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator applies and reverts migrations on one database.
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+}
+
+// New returns a Migrator for db using the embedded migrations of dialect.
+func New(db *sql.DB, dialect Dialect) (*Migrator, error) {
+	migrations, err := Load(files, dialect.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// Up applies every pending migration, each in its own transaction, and
+// returns the ones applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if _, ok := done[mig.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, mig, mig.Up, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", mig.Version, mig.Name); err != nil {
+				return err
+			}
+			log.Printf("⬆️ Applied migration %d_%s", mig.Version, mig.Name)
+			applied = append(applied, mig)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the most recently applied migration and returns it.
+func (m *Migrator) Down(ctx context.Context) (Migration, error) {
+	var reverted Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mig := m.migrations[i]
+			if _, ok := done[mig.Version]; !ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, mig, mig.Down, "DELETE FROM schema_migrations WHERE version = $1", mig.Version); err != nil {
+				return err
+			}
+			log.Printf("⬇️ Reverted migration %d_%s", mig.Version, mig.Name)
+			reverted = mig
+			return nil
+		}
+		return ErrNoMigrations
+	})
+	return reverted, err
+}
+
+// Status lists every known migration with when it was applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			s := Status{Migration: mig}
+			if at, ok := done[mig.Version]; ok {
+				s.AppliedAt = &at
+			}
+			statuses = append(statuses, s)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// locked runs fn on one connection holding the migration lock, after making
+// sure schema_migrations exists.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.dialect.lock != "" {
+		if _, err := conn.ExecContext(ctx, m.dialect.lock); err != nil {
+			return fmt.Errorf("taking the migration lock: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), m.dialect.unlock)
+	}
+
+	if _, err := conn.ExecContext(ctx, m.dialect.createTable); err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+	return fn(conn)
+}
+
+// apply runs a migration's SQL and records it in the same transaction.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig Migration, body, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("recording migration %d_%s: %w", mig.Version, mig.Name, err)
+	}
+	return tx.Commit()
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	done := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		done[version] = at
+	}
+	return done, rows.Err()
+}
@@ -0,0 +1,164 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	_ "modernc.org/sqlite"
+)
+
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db")+
+		"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// tables lists the tables in db other than schema_migrations.
+func tables(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT IN ('schema_migrations', 'sqlite_sequence') ORDER BY name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestUpAndDownRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	m, err := New(db, SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(m.migrations) {
+		t.Fatalf("applied %d migrations, want %d", len(applied), len(m.migrations))
+	}
+	schema := tables(t, db)
+	if again, err := m.Up(ctx); err != nil || len(again) != 0 {
+		t.Errorf("second Up applied %v, %v", again, err)
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		reverted, err := m.Down(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reverted.Version != m.migrations[i].Version {
+			t.Fatalf("reverted %d, want %d", reverted.Version, m.migrations[i].Version)
+		}
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range statuses {
+			if pending := s.AppliedAt == nil; pending != (s.Version >= reverted.Version) {
+				t.Errorf("after reverting %d, migration %d applied at %v", reverted.Version, s.Version, s.AppliedAt)
+			}
+		}
+	}
+	if left := tables(t, db); len(left) != 0 {
+		t.Errorf("tables left after reverting everything: %v", left)
+	}
+	if _, err := m.Down(ctx); !errors.Is(err, ErrNoMigrations) {
+		t.Errorf("Down with nothing applied = %v, want ErrNoMigrations", err)
+	}
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("migrating up again: %v", err)
+	}
+	if again := tables(t, db); !slices.Equal(again, schema) {
+		t.Errorf("schema after down and up = %v, want %v", again, schema)
+	}
+}
+
+func TestMigratorsTakeTheLock(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	if _, err := db.Exec("CREATE TABLE migration_lock (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+	// Taking the lock fails while another migrator holds it, as
+	// pg_advisory_lock would block.
+	dialect := SQLite
+	dialect.lock = "INSERT INTO migration_lock VALUES (1)"
+	dialect.unlock = "DELETE FROM migration_lock"
+	first, err := New(db, dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := New(db, dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = first.locked(ctx, func(*sql.Conn) error {
+		_, err := second.Up(ctx)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "taking the migration lock") {
+		t.Fatalf("migrating while another migrator holds the lock: %v", err)
+	}
+	if tables := tables(t, db); !slices.Equal(tables, []string{"migration_lock"}) {
+		t.Errorf("migrated without the lock: %v", tables)
+	}
+
+	if _, err := second.Up(ctx); err != nil {
+		t.Errorf("the lock wasn't released: %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		files []string
+		err   string
+	}{
+		{"valid", []string{"0001_a.up.sql", "0001_a.down.sql", "0002_b.up.sql", "0002_b.down.sql"}, ""},
+		{"missing down", []string{"0001_a.up.sql"}, "needs both an up and a down file"},
+		{"no version", []string{"a.up.sql", "a.down.sql"}, "must start with a version number"},
+		{"no direction", []string{"0001_a.sql"}, "must end in .up.sql or .down.sql"},
+		{"names differ", []string{"0001_a.up.sql", "0001_b.down.sql"}, "is named both"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for _, name := range tt.files {
+				fsys["m/"+name] = &fstest.MapFile{Data: []byte("SELECT 1")}
+			}
+			migrations, err := Load(fsys, "m")
+			if tt.err == "" {
+				if err != nil || len(migrations) != 2 || migrations[0].Version != 1 || migrations[1].Name != "b" {
+					t.Errorf("Load = %+v, %v", migrations, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Load error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,5 @@
+ALTER TABLE account DROP CONSTRAINT IF EXISTS account_last_player_fkey;
+DROP TABLE IF EXISTS session;
+DROP TABLE IF EXISTS drawing;
+DROP TABLE IF EXISTS player;
+DROP TABLE IF EXISTS account;
@@ -0,0 +1,51 @@
+-- Accounts, players, drawings and sessions. Written to also apply to
+-- databases created before migrations existed.
+CREATE TABLE IF NOT EXISTS account (
+	id SERIAL PRIMARY KEY,
+	username TEXT UNIQUE NOT NULL,
+	password_hash TEXT NOT NULL,
+	last_player_id INT,
+	created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS player (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	x INT NOT NULL DEFAULT 100,
+	y INT NOT NULL DEFAULT 100,
+	color TEXT NOT NULL DEFAULT 'teal',
+	health INT NOT NULL DEFAULT 100,
+	account_id INT REFERENCES account(id),
+	created_at TIMESTAMP DEFAULT NOW()
+);
+
+ALTER TABLE player ADD COLUMN IF NOT EXISTS account_id INT REFERENCES account(id);
+ALTER TABLE player ADD COLUMN IF NOT EXISTS health INT NOT NULL DEFAULT 100;
+
+CREATE TABLE IF NOT EXISTS drawing (
+	id SERIAL PRIMARY KEY,
+	player_id INT REFERENCES player(id),
+	x INT NOT NULL,
+	y INT NOT NULL,
+	color TEXT NOT NULL DEFAULT 'black',
+	size INT NOT NULL DEFAULT 2,
+	image TEXT NOT NULL  -- base64 or other encoding of the full image
+);
+
+CREATE TABLE IF NOT EXISTS session (
+	id TEXT PRIMARY KEY,
+	account_id INT NOT NULL REFERENCES account(id),
+	user_agent TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS session_account_idx ON session (account_id);
+
+DO $$ BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'account_last_player_fkey') THEN
+		ALTER TABLE account ADD CONSTRAINT account_last_player_fkey
+			FOREIGN KEY (last_player_id) REFERENCES player(id);
+	END IF;
+END $$;
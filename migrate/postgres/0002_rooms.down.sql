@@ -0,0 +1,3 @@
+ALTER TABLE drawing DROP COLUMN IF EXISTS room_id;
+ALTER TABLE player DROP COLUMN IF EXISTS room_id;
+DROP TABLE IF EXISTS room;
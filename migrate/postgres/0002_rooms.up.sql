@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS room (
+	id SERIAL PRIMARY KEY,
+	slug TEXT UNIQUE NOT NULL,
+	name TEXT NOT NULL,
+	capacity INT NOT NULL DEFAULT 50,
+	created_by INT REFERENCES account(id),
+	created_at TIMESTAMP DEFAULT NOW()
+);
+
+INSERT INTO room (slug, name) VALUES ('lobby', 'Lobby') ON CONFLICT (slug) DO NOTHING;
+
+-- Scope players and drawings to rooms; everything older lives in the lobby
+ALTER TABLE player ADD COLUMN IF NOT EXISTS room_id INT REFERENCES room(id);
+ALTER TABLE drawing ADD COLUMN IF NOT EXISTS room_id INT REFERENCES room(id);
+UPDATE player SET room_id = (SELECT id FROM room WHERE slug = 'lobby') WHERE room_id IS NULL;
+UPDATE drawing SET room_id = (SELECT id FROM room WHERE slug = 'lobby') WHERE room_id IS NULL;
+CREATE INDEX IF NOT EXISTS player_room_idx ON player (room_id);
+CREATE INDEX IF NOT EXISTS drawing_room_idx ON drawing (room_id);
@@ -0,0 +1,15 @@
+-- Chat keeps the speaker's name and position so history outlives the player
+CREATE TABLE IF NOT EXISTS chat_message (
+	id BIGSERIAL PRIMARY KEY,
+	room_id INT NOT NULL REFERENCES room(id),
+	account_id INT REFERENCES account(id),
+	player_id INT NOT NULL,
+	name TEXT NOT NULL,
+	text TEXT NOT NULL,
+	shout BOOLEAN NOT NULL DEFAULT FALSE,
+	x INT NOT NULL,
+	y INT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS chat_message_room_idx ON chat_message (room_id, id);
@@ -0,0 +1,2 @@
+ALTER TABLE account ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'online';
+ALTER TABLE account ADD COLUMN IF NOT EXISTS status_text TEXT NOT NULL DEFAULT '';
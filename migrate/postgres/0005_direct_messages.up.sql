@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS direct_message (
+	id BIGSERIAL PRIMARY KEY,
+	sender_id INT NOT NULL REFERENCES account(id),
+	recipient_id INT NOT NULL REFERENCES account(id),
+	text TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	read_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS direct_message_pair_idx ON direct_message (sender_id, recipient_id, id);
+CREATE INDEX IF NOT EXISTS direct_message_unread_idx ON direct_message (recipient_id) WHERE read_at IS NULL;
@@ -0,0 +1,5 @@
+UPDATE account SET last_player_id = NULL;
+DROP TABLE IF EXISTS session;
+DROP TABLE IF EXISTS drawing;
+DROP TABLE IF EXISTS player;
+DROP TABLE IF EXISTS account;
@@ -0,0 +1,41 @@
+-- Accounts, players, drawings and sessions. SQLite can't add a foreign key to
+-- an existing table, so account.last_player_id declares it up front.
+CREATE TABLE account (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT UNIQUE NOT NULL,
+	password_hash TEXT NOT NULL,
+	last_player_id INT REFERENCES player(id),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE player (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	x INT NOT NULL DEFAULT 100,
+	y INT NOT NULL DEFAULT 100,
+	color TEXT NOT NULL DEFAULT 'teal',
+	health INT NOT NULL DEFAULT 100,
+	account_id INT REFERENCES account(id),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE drawing (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	player_id INT REFERENCES player(id),
+	x INT NOT NULL,
+	y INT NOT NULL,
+	color TEXT NOT NULL DEFAULT 'black',
+	size INT NOT NULL DEFAULT 2,
+	image TEXT NOT NULL  -- base64 or other encoding of the full image
+);
+
+CREATE TABLE session (
+	id TEXT PRIMARY KEY,
+	account_id INT NOT NULL REFERENCES account(id),
+	user_agent TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP
+);
+
+CREATE INDEX session_account_idx ON session (account_id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS drawing_room_idx;
+DROP INDEX IF EXISTS player_room_idx;
+ALTER TABLE drawing DROP COLUMN room_id;
+ALTER TABLE player DROP COLUMN room_id;
+DROP TABLE IF EXISTS room;
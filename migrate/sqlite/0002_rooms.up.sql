@@ -0,0 +1,19 @@
+CREATE TABLE room (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	slug TEXT UNIQUE NOT NULL,
+	name TEXT NOT NULL,
+	capacity INT NOT NULL DEFAULT 50,
+	created_by INT REFERENCES account(id),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO room (slug, name) VALUES ('lobby', 'Lobby') ON CONFLICT (slug) DO NOTHING;
+
+-- Scope players and drawings to rooms. SQLite can't drop a column with a
+-- foreign key, so room_id is a plain column to keep this reversible.
+ALTER TABLE player ADD COLUMN room_id INT;
+ALTER TABLE drawing ADD COLUMN room_id INT;
+UPDATE player SET room_id = (SELECT id FROM room WHERE slug = 'lobby') WHERE room_id IS NULL;
+UPDATE drawing SET room_id = (SELECT id FROM room WHERE slug = 'lobby') WHERE room_id IS NULL;
+CREATE INDEX player_room_idx ON player (room_id);
+CREATE INDEX drawing_room_idx ON drawing (room_id);
@@ -0,0 +1,2 @@
+ALTER TABLE account DROP COLUMN status_text;
+ALTER TABLE account DROP COLUMN status;
@@ -0,0 +1,2 @@
+ALTER TABLE account ADD COLUMN status TEXT NOT NULL DEFAULT 'online';
+ALTER TABLE account ADD COLUMN status_text TEXT NOT NULL DEFAULT '';
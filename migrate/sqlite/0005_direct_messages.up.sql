@@ -0,0 +1,11 @@
+CREATE TABLE direct_message (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	sender_id INT NOT NULL REFERENCES account(id),
+	recipient_id INT NOT NULL REFERENCES account(id),
+	text TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	read_at TIMESTAMP
+);
+
+CREATE INDEX direct_message_pair_idx ON direct_message (sender_id, recipient_id, id);
+CREATE INDEX direct_message_unread_idx ON direct_message (recipient_id) WHERE read_at IS NULL;
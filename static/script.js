@@ -34,16 +34,38 @@ constructBtn.addEventListener('click', () => {
     sidebar.classList.toggle('hidden');
 });
 
-const socket = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/ws");
+// The room comes from the page URL (/ourgatther?room=<slug>); the server
+// defaults to the lobby.
+const roomSlug = new URLSearchParams(location.search).get("room") || "lobby";
+let socket = null;
+
+// After a drop we reconnect with the resume token and the last broadcast seq
+// we saw; the server replays what we missed, or we reload if it can't.
+let resumeToken = null;
+let lastSeq = 0;
+
+function connect() {
+    let url = (location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/ws?room=" + encodeURIComponent(roomSlug);
+    if (resumeToken) url += `&resume=${resumeToken}&seq=${lastSeq}`;
+    socket = new WebSocket(url, ["ourgatther.v1"]);
+
+    socket.onopen = () => {
+        console.log("Connected to WebSocket");
+        // The server sends a snapshot of our view on join, then per-tick deltas
+        sendView();
+    };
 
-socket.onopen = () => {
-    console.log("Connected to WebSocket");
-    socket.send(JSON.stringify({ type: "get_players" }));
-};
+    socket.onerror = (err) => {
+        console.error("WebSocket error:", err);
+    };
 
-socket.onerror = (err) => {
-    console.error("WebSocket error:", err);
-};
+    socket.onclose = (event) => {
+        console.warn(`WebSocket closed (${event.code}), reconnecting`);
+        setTimeout(connect, 1000);
+    };
+
+    socket.onmessage = handleMessage;
+}
 
 let players = {};
 let myId = null;
@@ -58,38 +80,72 @@ window.debugPlayerState = function() {
     console.log(`  medMode: ${medMode}, medModePlayerId: ${medModePlayerId}`);
 };
 
-socket.onmessage = (event) => {
-    const msg = JSON.parse(event.data);
+function applyServerPosition(id, x, y) {
+    const c = players[id];
+    if (!c) return;
+    c.style.opacity = "";
+    if (!playerPositions[id]) {
+        playerPositions[id] = {
+            currentX: parseInt(c.style.left) || x,
+            currentY: parseInt(c.style.top) || y,
+            targetX: x,
+            targetY: y
+        };
+    } else if (id !== myId) {
+        // For other players, always update from server
+        playerPositions[id].targetX = x;
+        playerPositions[id].targetY = y;
+    }
+    // For own player, ignore server updates to prevent rubber-banding:
+    // client-side prediction takes priority for the controlled player
+}
+
+function handleMessage(event) {
+    handleServerMessage(JSON.parse(event.data));
+}
+
+function handleServerMessage(msg) {
+    if (msg.seq) lastSeq = msg.seq;
     switch (msg.type) {
+        case "batch":
+            msg.data.forEach(handleServerMessage);
+            break;
+        case "welcome":
+            if (resumeToken && !msg.data.resumed) {
+                location.reload();
+                return;
+            }
+            resumeToken = msg.data.resumeToken;
+            if (!msg.data.resumed) lastSeq = msg.data.seq;
+            break;
         case "players":
         console.log(`📥 Received ${msg.data.length} players:`, msg.data);
         msg.data.forEach(drawPlayer);
         break;
-        case "new_player":
-        drawPlayer(msg.data);
-        break;
-        case "move":
-            const c = players[msg.data.id];
-            if (c) {
-                if (!playerPositions[msg.data.id]) {
-                    playerPositions[msg.data.id] = {
-                        currentX: parseInt(c.style.left) || msg.data.x,
-                        currentY: parseInt(c.style.top) || msg.data.y,
-                        targetX: msg.data.x,
-                        targetY: msg.data.y
-                    };
-                } else {
-                    // Only apply server position updates to other players or as corrections
-                    if (msg.data.id !== myId) {
-                        // For other players, always update from server
-                        playerPositions[msg.data.id].targetX = msg.data.x;
-                        playerPositions[msg.data.id].targetY = msg.data.y;
-                    } else {
-                        // For own player, ignore server updates to prevent rubber-banding
-                        // Client-side prediction takes priority for controlled player
-                        console.log(`Ignoring server position update for controlled player ${myId}`);
-                    }
-                }
+        case "player_enter_view":
+            drawPlayer(msg.data);
+            applyServerPosition(msg.data.id, msg.data.x, msg.data.y);
+            break;
+        case "player_leave_view":
+            if (msg.data.id !== myId) removePlayer(msg.data.id);
+            break;
+        case "snapshot":
+            console.log(`📥 Received snapshot at tick ${msg.data.tick} with ${(msg.data.players || []).length} players`);
+            (msg.data.players || []).forEach(p => {
+                drawPlayer(p);
+                applyServerPosition(p.id, p.x, p.y);
+            });
+            break;
+        case "tick":
+            msg.data.moves.forEach(m => applyServerPosition(m.id, m.x, m.y));
+            break;
+        case "correction":
+            // The server trimmed our last move; snap back to its position
+            console.warn(`↩️ Move corrected (${msg.data.reason}) to (${msg.data.x}, ${msg.data.y})`);
+            if (playerPositions[msg.data.id]) {
+                const pos = playerPositions[msg.data.id];
+                pos.currentX = pos.targetX = msg.data.x;
+                pos.currentY = pos.targetY = msg.data.y;
             }
             break;
         case "name_changed":
@@ -104,6 +160,10 @@ socket.onmessage = (event) => {
             drawPlayer(msg.data);
             controlPlayer(myId);
             break;
+        case "player_offline":
+            // Nobody controls this player any more; it comes back on its next move
+            if (players[msg.data.id]) players[msg.data.id].style.opacity = "0.5";
+            break;
         case "player_deleted":
             removePlayer(msg.data.id);
             break;
@@ -145,27 +205,196 @@ socket.onmessage = (event) => {
                 console.warn(`❌ Cannot update health for unknown player ${playerId}`);
             }
             break;
-        case "spawn_bullet":
-            const bulletFromId = msg.data.fromId;
-            const bulletTargetX = msg.data.targetX;
-            const bulletTargetY = msg.data.targetY;
-            
-            // Spawn bullet for all clients
-            console.log(`🔄 Received bullet spawn from player ${bulletFromId}`);
-            spawnDirectionalBullet(bulletFromId, bulletTargetX, bulletTargetY, true);
+        case "projectile_spawn":
+            console.log(`🔄 Received ${msg.data.kind} ${msg.data.id} from player ${msg.data.fromId}`);
+            spawnProjectile(msg.data);
             break;
-        case "spawn_medkit":
-            const medkitFromId = msg.data.fromId;
-            const medkitTargetX = msg.data.targetX;
-            const medkitTargetY = msg.data.targetY;
-            
-            // Spawn med kit for all clients
-            console.log(`🔄 Received med kit spawn from player ${medkitFromId}`);
-            spawnDirectionalMedKit(medkitFromId, medkitTargetX, medkitTargetY, true);
+        case "projectile_hit":
+        case "projectile_despawn":
+            removeProjectile(msg.data.id);
+            break;
+        case "chat":
+            appendChat(msg.data);
+            break;
+        case "chat_history":
+            document.getElementById("chatLog").innerHTML = "";
+            msg.data.forEach(appendChat);
+            break;
+        case "presence_list":
+            Object.keys(presence).forEach(id => delete presence[id]);
+            msg.data.forEach(p => presence[p.accountId] = p);
+            renderPresence();
+            break;
+        case "presence":
+            if (msg.data.status === "offline") {
+                delete presence[msg.data.accountId];
+            } else {
+                presence[msg.data.accountId] = msg.data;
+            }
+            renderPresence();
+            break;
+        case "dm":
+            appendChat({ name: `DM ${msg.data.senderId} → ${msg.data.recipientId}`, text: msg.data.text });
+            break;
+        case "dm_unread":
+            msg.data.forEach(u => appendChat({ name: "📬", text: `${u.count} unread message(s) from account ${u.from}` }));
+            break;
+        case "dm_read":
+            console.log(`👀 Account ${msg.data.readerId} read messages up to ${msg.data.upTo}`);
+            break;
+        case "peer_enter":
+            openCall(msg.data.peerId, msg.data.initiator);
+            break;
+        case "peer_leave":
+            closeCall(msg.data.peerId);
+            break;
+        case "rtc_offer":
+        case "rtc_answer":
+        case "rtc_ice":
+            handleSignal(msg.type, msg.data);
+            break;
+        case "error":
+            console.warn(`❌ Server rejected ${msg.data.type || "message"}: [${msg.data.code}] ${msg.data.message}`);
+            if (msg.data.code === "forbidden" && msg.data.type === "control_player") {
+                myId = null;
+                updateUIBasedOnControl();
+                updatePlayerControlVisuals();
+                alert("You can't control that player: " + msg.data.message);
+            }
             break;
 
     }
-};
+}
+
+const presence = {};
+const statusIcons = { online: "🟢", away: "🟡", busy: "🔴" };
+
+function renderPresence() {
+    const list = document.getElementById("presence");
+    list.innerHTML = "";
+    Object.values(presence).sort((a, b) => a.username.localeCompare(b.username)).forEach(p => {
+        const line = document.createElement("div");
+        line.textContent = `${statusIcons[p.status] || ""} ${p.username}${p.text ? ` (${p.text})` : ""}`;
+        list.appendChild(line);
+    });
+}
+
+document.getElementById("statusSelect").addEventListener("change", (e) => {
+    socket.send(JSON.stringify({ type: "set_status", data: { status: e.target.value, text: "" } }));
+});
+
+function appendChat(m) {
+    const log = document.getElementById("chatLog");
+    const line = document.createElement("div");
+    if (m.shout) line.className = "shout";
+    line.textContent = `${m.name}${m.shout ? " shouts" : ""}: ${m.text}`;
+    log.appendChild(line);
+    log.scrollTop = log.scrollHeight;
+}
+
+document.getElementById("chatInput").addEventListener("keydown", (e) => {
+    if (e.key !== "Enter") return;
+    let text = e.target.value.trim();
+    const dm = text.match(/^\/dm (\d+) (.+)$/);
+    if (dm) {
+        socket.send(JSON.stringify({ type: "dm", data: { to: parseInt(dm[1]), text: dm[2] } }));
+        e.target.value = "";
+        return;
+    }
+    const shout = text.startsWith("/shout ");
+    if (shout) text = text.slice("/shout ".length).trim();
+    if (!text) return;
+    socket.send(JSON.stringify({ type: "chat", data: { text, shout } }));
+    e.target.value = "";
+});
+
+// Proximity calls: the server says who is nearby and relays signaling;
+// audio flows peer to peer.
+const calls = {};
+let localStream = null;
+
+async function getLocalStream() {
+    if (!localStream && navigator.mediaDevices) {
+        try {
+            localStream = await navigator.mediaDevices.getUserMedia({ audio: true });
+        } catch (err) {
+            console.warn("🎙️ No microphone, joining calls listen-only:", err);
+            localStream = new MediaStream();
+        }
+    }
+    return localStream;
+}
+
+function sendSignal(type, data) {
+    socket.send(JSON.stringify({ type, data }));
+}
+
+async function openCall(peerId, initiator) {
+    if (calls[peerId]) return calls[peerId];
+    const pc = new RTCPeerConnection({ iceServers: [{ urls: "stun:stun.l.google.com:19302" }] });
+    calls[peerId] = pc;
+    pc.onicecandidate = (e) => {
+        if (e.candidate) sendSignal("rtc_ice", { to: peerId, candidate: e.candidate.toJSON() });
+    };
+    pc.ontrack = (e) => {
+        let audio = document.getElementById(`call-${peerId}`);
+        if (!audio) {
+            audio = document.createElement("audio");
+            audio.id = `call-${peerId}`;
+            audio.autoplay = true;
+            document.body.appendChild(audio);
+        }
+        audio.srcObject = e.streams[0];
+    };
+    const stream = await getLocalStream();
+    if (stream) stream.getTracks().forEach(t => pc.addTrack(t, stream));
+    if (initiator) {
+        await pc.setLocalDescription(await pc.createOffer({ offerToReceiveAudio: true }));
+        sendSignal("rtc_offer", { to: peerId, sdp: pc.localDescription.sdp });
+    }
+    return pc;
+}
+
+function closeCall(peerId) {
+    const pc = calls[peerId];
+    if (!pc) return;
+    pc.close();
+    delete calls[peerId];
+    const audio = document.getElementById(`call-${peerId}`);
+    if (audio) audio.remove();
+}
+
+async function handleSignal(type, data) {
+    const pc = calls[data.from] || await openCall(data.from, false);
+    if (type === "rtc_offer") {
+        await pc.setRemoteDescription({ type: "offer", sdp: data.sdp });
+        await pc.setLocalDescription(await pc.createAnswer());
+        sendSignal("rtc_answer", { to: data.from, sdp: pc.localDescription.sdp });
+    } else if (type === "rtc_answer") {
+        await pc.setRemoteDescription({ type: "answer", sdp: data.sdp });
+    } else {
+        await pc.addIceCandidate(data.candidate);
+    }
+}
+
+// Tell the server how much of the world we show, so it sends us the players
+// in it. Without a controlled player it uses our camera centre.
+function sendView() {
+    if (!socket || socket.readyState !== WebSocket.OPEN) return;
+    socket.send(JSON.stringify({
+        type: "view",
+        data: {
+            x: Math.round(targetCameraX + window.innerWidth / 2),
+            y: Math.round(targetCameraY + window.innerHeight / 2),
+            width: Math.min(window.innerWidth, 4096),
+            height: Math.min(window.innerHeight, 4096)
+        }
+    }));
+}
+
+window.addEventListener("resize", sendView);
+
+connect();
 
 let cameraOffsetX = 0;
 let cameraOffsetY = 0;
@@ -249,7 +478,7 @@ function createPlayer() {
     if (name) {
         socket.send(JSON.stringify({ 
             type: "create", 
-            data: { name, accountId: parseInt(accountId) } 
+            data: { name } 
         }));
     }
 }
@@ -290,7 +519,7 @@ function drawPlayer(c) {
 
     // Initialize health if not already set
     if (playerHealth[c.id] === undefined) {
-        playerHealth[c.id] = 100;
+        playerHealth[c.id] = c.health ?? 100;
     }
     updateHealthBar(c.id);
     
@@ -522,217 +751,39 @@ document.addEventListener('click', function(e) {
     }
 }, true); // Use capture phase to intercept all clicks
 
-function spawnDirectionalBullet(fromId, targetX, targetY, fromWebSocket = false) {
-    const from = players[fromId];
-    if (!from) {
-        console.warn(`Cannot spawn bullet: player ${fromId} not found`);
-        return;
-    }
-
-    const bullet = document.createElement("div");
-    bullet.className = "bullet";
-    bullet.style.position = "absolute";
-    
-    // Start from center of shooter
-    const startX = parseInt(from.style.left) + 30;
-    const startY = parseInt(from.style.top) + 30;
-    
-    bullet.style.left = startX + "px";
-    bullet.style.top = startY + "px";
-
-    document.getElementById("game").appendChild(bullet);
-    console.log(`Bullet spawned: (${startX},${startY}) → (${targetX},${targetY}) ${fromWebSocket ? '[WebSocket]' : '[Local]'}`);
-
-    let x = startX;
-    let y = startY;
-
-    // Calculate direction vector and normalize for consistent speed
-    const dx = targetX - startX;
-    const dy = targetY - startY;
-    const distance = Math.hypot(dx, dy);
-    const speed = 15; // pixels per step - same as player-to-player bullets
-    const stepX = (dx / distance) * speed;
-    const stepY = (dy / distance) * speed;
-    
-    let stepCount = 0;
-    const maxSteps = Math.ceil(distance / speed) + 20; // Allow bullets to travel further
-    
-    const interval = setInterval(() => {
-        x += stepX;
-        y += stepY;
-        bullet.style.left = x + "px";
-        bullet.style.top = y + "px";
-        stepCount++;
-
-        // Check collision with all players (only apply damage if not from WebSocket or if we're the shooter)
-        let hit = false;
-        const allPlayerIds = Object.keys(players);
-        const eligiblePlayers = allPlayerIds.filter(id => parseInt(id) !== fromId);
-        const debugPlayers = allPlayerIds.map(id => `${id}@(${parseInt(players[id].style.left)},${parseInt(players[id].style.top)})`).join(', ');
-        
-        if (stepCount % 10 === 0) { // Log every 10th step to reduce spam
-            console.log(`🔍 Bullet from ${fromId} at (${x.toFixed(0)}, ${y.toFixed(0)}) - Total players: ${allPlayerIds.length}, Eligible targets: ${eligiblePlayers.length} (${eligiblePlayers.join(',')})`);
-            console.log(`🔍 Player positions: ${debugPlayers}`);
-        }
-        
-        // Special logging when bullet is near player 19's X coordinate
-        if (Math.abs(x - 414) < 30 && eligiblePlayers.includes('19')) {
-            console.log(`🎯 TRAJECTORY CHECK: Bullet at (${x.toFixed(0)}, ${y.toFixed(0)}) is near Player19's X position (414)`);
-            console.log(`🎯 Player19 is at (414, 416) - Y difference: ${Math.abs(y - 416).toFixed(1)} pixels`);
-        }
-        
-        Object.keys(players).forEach(playerId => {
-            if (parseInt(playerId) === fromId) return; // Don't hit self
-            
-            const player = players[playerId];
-            if (player) {
-                // Player dimensions (60px wide, full element height including buttons)
-                const playerLeft = parseInt(player.style.left);
-                const playerTop = parseInt(player.style.top);
-                const playerWidth = 60;
-                const playerHeight = player.offsetHeight; // Full element height including buttons
-                
-                // Check if bullet position is within player rectangle
-                const bulletHitsPlayer = (
-                    x >= playerLeft && 
-                    x <= playerLeft + playerWidth &&
-                    y >= playerTop && 
-                    y <= playerTop + playerHeight
-                );
-                
-                // Debug logging for close misses with detailed collision info
-                const distance = Math.hypot((playerLeft + playerWidth/2) - x, (playerTop + playerHeight/2) - y);
-                if (distance < 150 && stepCount % 3 === 0) { // Increased range and frequency for debugging
-                    const playerRight = playerLeft + playerWidth;
-                    const playerBottom = playerTop + playerHeight;
-                    console.log(`🔍 Close check: Bullet(${x.toFixed(0)}, ${y.toFixed(0)}) vs Player${playerId}(${playerLeft}, ${playerTop})`);
-                    console.log(`🔍   Player bounds: Left=${playerLeft}, Right=${playerRight}, Top=${playerTop}, Bottom=${playerBottom}`);
-                    console.log(`🔍   X check: ${x} >= ${playerLeft} = ${x >= playerLeft}, ${x} <= ${playerRight} = ${x <= playerRight}`);
-                    console.log(`🔍   Y check: ${y} >= ${playerTop} = ${y >= playerTop}, ${y} <= ${playerBottom} = ${y <= playerBottom}`);
-                    console.log(`🔍   Final hit: ${bulletHitsPlayer}, distance: ${distance.toFixed(1)}`);
-                }
-                
-                if (bulletHitsPlayer && !hit) {
-                    console.log(`🎯 Directional bullet hit player ${playerId}! Bullet at (${x.toFixed(0)}, ${y.toFixed(0)}) hit player at (${playerLeft}, ${playerTop})`);
-                    console.log(`🎯 Collision check: fromWebSocket=${fromWebSocket}, fromId=${fromId}, myId=${myId}, shouldApplyDamage=${!fromWebSocket || fromId === myId}`);
-                    // Only the original shooter applies damage to prevent duplicates
-                    if (!fromWebSocket || fromId === myId) {
-                        console.log(`🎯 Applying damage from ${fromWebSocket ? 'WebSocket' : 'local'} bullet`);
-                        applyDamage(parseInt(playerId));
-                    } else {
-                        console.log(`🎯 Skipping damage - not the original shooter`);
-                    }
-                    hit = true;
-                }
-            }
-        });
-        
-        if (hit) {
-            bullet.remove();
-            clearInterval(interval);
-            return;
-        }
-
-        // Clean up bullet after max steps or if it goes off screen
-        if (stepCount >= maxSteps || x < -100 || x > 10100 || y < -100 || y > 10100) {
-            bullet.remove();
-            clearInterval(interval);
+// Projectiles are simulated by the server; clients only animate them along
+// the server's velocity until a projectile_hit or projectile_despawn arrives.
+let projectiles = {};
+
+function spawnProjectile(p) {
+    const el = document.createElement("div");
+    el.className = p.kind === "medkit" ? "medkit" : "bullet";
+    el.style.position = "absolute";
+    el.style.left = p.x + "px";
+    el.style.top = p.y + "px";
+    document.getElementById("game").appendChild(el);
+
+    const speed = Math.hypot(p.vx, p.vy);
+    const maxAge = speed > 0 ? (p.range / speed) * 1000 : 0;
+    const start = performance.now();
+    projectiles[p.id] = el;
+
+    function step(now) {
+        if (!projectiles[p.id]) return;
+        const age = Math.min(now - start, maxAge);
+        el.style.left = (p.x + p.vx * age / 1000) + "px";
+        el.style.top = (p.y + p.vy * age / 1000) + "px";
+        if (age < maxAge) {
+            requestAnimationFrame(step);
         }
-
-    }, 16); // Same update rate as other bullets
-}
-
-function spawnDirectionalMedKit(fromId, targetX, targetY, fromWebSocket = false) {
-    const from = players[fromId];
-    if (!from) {
-        console.warn(`Cannot spawn med kit: player ${fromId} not found`);
-        return;
     }
+    requestAnimationFrame(step);
+}
 
-    const medkit = document.createElement("div");
-    medkit.className = "medkit";
-    medkit.style.position = "absolute";
-    
-    // Start from center of medic
-    const startX = parseInt(from.style.left) + 30;
-    const startY = parseInt(from.style.top) + 30;
-    
-    medkit.style.left = startX + "px";
-    medkit.style.top = startY + "px";
-
-    document.getElementById("game").appendChild(medkit);
-    console.log(`Med kit spawned: (${startX},${startY}) → (${targetX},${targetY}) ${fromWebSocket ? '[WebSocket]' : '[Local]'}`);
-
-    let x = startX;
-    let y = startY;
-
-    // Calculate direction vector and normalize for consistent speed
-    const dx = targetX - startX;
-    const dy = targetY - startY;
-    const distance = Math.hypot(dx, dy);
-    const speed = 12; // Slightly slower than bullets for balance
-    const stepX = (dx / distance) * speed;
-    const stepY = (dy / distance) * speed;
-    
-    let stepCount = 0;
-    const maxSteps = Math.ceil(distance / speed) + 20; // Allow med kits to travel further
-    
-    const interval = setInterval(() => {
-        x += stepX;
-        y += stepY;
-        medkit.style.left = x + "px";
-        medkit.style.top = y + "px";
-        stepCount++;
-
-        // Check collision with all players (only apply healing if not from WebSocket or if we're the medic)
-        let hit = false;
-        Object.keys(players).forEach(playerId => {
-            if (parseInt(playerId) === fromId) return; // Don't heal self
-            
-            const player = players[playerId];
-            if (player) {
-                // Player dimensions (60px wide, full element height including buttons)
-                const playerLeft = parseInt(player.style.left);
-                const playerTop = parseInt(player.style.top);
-                const playerWidth = 60;
-                const playerHeight = player.offsetHeight; // Full element height including buttons
-                
-                // Check if med kit position is within player rectangle
-                const medkitHitsPlayer = (
-                    x >= playerLeft && 
-                    x <= playerLeft + playerWidth &&
-                    y >= playerTop && 
-                    y <= playerTop + playerHeight
-                );
-                
-                if (medkitHitsPlayer && !hit) {
-                    console.log(`💊 Med kit hit player ${playerId}! Med kit at (${x.toFixed(0)}, ${y.toFixed(0)}) hit player at (${playerLeft}, ${playerTop})`);
-                    console.log(`💊 Collision check: fromWebSocket=${fromWebSocket}, fromId=${fromId}, myId=${myId}, shouldApplyHealing=${!fromWebSocket || fromId === myId}`);
-                    // Only the original medic applies healing to prevent duplicates
-                    if (!fromWebSocket || fromId === myId) {
-                        console.log(`💊 Applying healing from ${fromWebSocket ? 'WebSocket' : 'local'} med kit`);
-                        applyHealing(parseInt(playerId));
-                    } else {
-                        console.log(`💊 Skipping healing - not the original medic`);
-                    }
-                    hit = true;
-                }
-            }
-        });
-        
-        if (hit) {
-            medkit.remove();
-            clearInterval(interval);
-            return;
-        }
-
-        // Clean up med kit after max steps or if it goes off screen
-        if (stepCount >= maxSteps || x < -100 || x > 10100 || y < -100 || y > 10100) {
-            medkit.remove();
-            clearInterval(interval);
-        }
-
-    }, 16); // Same update rate as bullets
+function removeProjectile(id) {
+    const el = projectiles[id];
+    if (el) el.remove();
+    delete projectiles[id];
 }
 
 function removePlayer(id) {
@@ -840,7 +891,7 @@ function controlPlayer(id) {
     // Send control command to server to update account's last_player_id
     socket.send(JSON.stringify({ 
         type: "control_player", 
-        data: { playerId: id, accountId: parseInt(accountId) } 
+        data: { playerId: id } 
     }));
     
     alert("You are now controlling: " + name);
@@ -852,7 +903,7 @@ let lastMoveTime = 0;
 let moveThrottleMs = 100; // Increased throttling to reduce network load on deployed version
 
 window.addEventListener("keydown", (e) => {
-    if (myId == null) return;
+    if (myId == null || e.target.tagName === "INPUT") return;
     keysPressed[e.key] = true;
 });
 
@@ -982,7 +1033,7 @@ canvas.addEventListener('mousemove', (e) => {
     redrawCanvas();
 
 
-    fetch('/draw', {
+    fetch('/draw?room=' + encodeURIComponent(roomSlug), {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
         body: JSON.stringify({ x, y, color: currentColor, size: 2, player_id: myId })
@@ -993,7 +1044,7 @@ canvas.addEventListener('mousemove', (e) => {
 });
 
 async function loadDrawings() {
-    const res = await fetch('/drawings');
+    const res = await fetch('/drawings?room=' + encodeURIComponent(roomSlug));
     const data = await res.json();
     console.log("Drawings fetched:", data);
     drawingCache = Array.isArray(data) ? data : [];
@@ -1071,7 +1122,6 @@ function spawnBullet(fromId, toId) {
             // Hit detection with generous radius
             if (distToTarget < 30) {
                 console.log(`🎯 Bullet hit player ${toId}! Distance: ${distToTarget.toFixed(1)}px`);
-                applyDamage(toId);
                 bullet.remove();
                 clearInterval(interval);
                 return;
@@ -1087,119 +1137,6 @@ function spawnBullet(fromId, toId) {
 
     }, 16); // Faster update rate for smoother bullets
 }
-function applyDamage(id) {
-    console.log(`💥 applyDamage called: Player ${id}, current health: ${playerHealth[id]}`);
-    if (playerHealth[id] === undefined) {
-        console.warn(`❌ No health found for player ${id}`);
-        return;
-    }
-
-    const oldHealth = playerHealth[id];
-    playerHealth[id] -= 10;
-    console.log(`💥 Damage applied: Player ${id}: ${oldHealth} -> ${playerHealth[id]}`);
-    
-    // Send health change to server for synchronization
-    console.log(`📤 Sending damage WebSocket message for player ${id}`);
-    socket.send(JSON.stringify({ 
-        type: "health_change", 
-        data: { 
-            playerId: id, 
-            health: playerHealth[id],
-            type: "damage"
-        } 
-    }));
-    
-    if (playerHealth[id] <= 0) {
-        playerHealth[id] = 0;
-        updateHealthBar(id);
-        
-        console.log(`💀 Player ${id} has died and will be hidden`);
-        
-        // Disable gun mode if the dying player was in gun mode
-        if (gunMode && gunModePlayerId === id) {
-            gunMode = false;
-            gunModePlayerId = null;
-            document.body.style.cursor = "default";
-            console.log(`Gun mode disabled due to player ${id} death`);
-        }
-        
-        // Disable med mode if the dying player was in med mode
-        if (medMode && medModePlayerId === id) {
-            medMode = false;
-            medModePlayerId = null;
-            document.body.style.cursor = "default";
-            console.log(`Med mode disabled due to player ${id} death`);
-        }
-        
-        // Delete the player from database and remove from view
-        console.log(`🗑️ Deleting dead player ${id} from database`);
-        socket.send(JSON.stringify({ 
-            type: "delete_player", 
-            data: { id: id } 
-        }));
-        
-        // Remove player from local arrays and DOM
-        const playerDiv = players[id];
-        if (playerDiv) {
-            playerDiv.remove();
-        }
-        delete players[id];
-        delete playerHealth[id];
-        delete playerPositions[id];
-        console.log(`🫥 Player ${id} removed from game`);
-        
-        // If this was the controlled player, clear control and alert
-        if (id === myId) {
-            myId = null;
-            updateUIBasedOnControl();
-            alert("Your player has died! You need to create a new player to continue playing.");
-            console.log(`🎮 Control cleared for dead player ${id}`);
-        }
-    } else {
-        updateHealthBar(id);
-    }
-}
-
-function applyHealing(id) {
-    console.log(`💚 applyHealing called: Player ${id}, current health: ${playerHealth[id]}`);
-    if (playerHealth[id] === undefined) {
-        console.warn(`❌ No health found for player ${id}`);
-        return;
-    }
-
-    const oldHealth = playerHealth[id];
-    playerHealth[id] += 15; // Heal for 15 points
-    if (playerHealth[id] > 100) {
-        playerHealth[id] = 100; // Cap at 100
-    }
-    
-    console.log(`💚 Healing applied: Player ${id}: ${oldHealth} -> ${playerHealth[id]}`);
-    
-    // Send health change to server for synchronization
-    console.log(`📤 Sending healing WebSocket message for player ${id}`);
-    socket.send(JSON.stringify({ 
-        type: "health_change", 
-        data: { 
-            playerId: id, 
-            health: playerHealth[id],
-            type: "heal"
-        } 
-    }));
-    
-    updateHealthBar(id);
-    
-    // Remove death visual effects if player was dead
-    const playerDiv = players[id];
-    if (playerDiv && playerDiv.style.opacity === "0.5") {
-        playerDiv.style.opacity = "1";
-        playerDiv.title = "";
-        // Remove respawn button if it exists
-        const respawnBtn = playerDiv.querySelector('button[style*="44ff44"]');
-        if (respawnBtn) {
-            respawnBtn.remove();
-        }
-    }
-}
 
 
 function checkCollisionWithPlayer(player, x, y) {